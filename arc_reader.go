@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ARC files start with the filedesc record describing the file
+const arcMagic = "filedesc://"
+
+// Longest header line considered when looking for the next ARC record
+const MAX_ARC_HEADER_LINE = 2048
+
+// Layout of the 14-digit archive date of ARC records
+const ARC_DATE_LAYOUT = "20060102150405"
+
+// Fields of an ARC v1 header line
+type arcHeaderLine struct {
+	Url         string
+	IpAddress   string
+	Date        string
+	ContentType string
+	Length      string
+}
+
+// Splits an ARC v1 header line: URL, IP address, archive date, content type and length.
+// The fields are read from the end because some crawlers left spaces in the URLs.
+func splitArcHeaderLine(line string) (*arcHeaderLine, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return nil, fmt.Errorf("malformed ARC header line %q", line)
+	}
+	last := len(fields) - 1
+	header := &arcHeaderLine{
+		Url:         strings.Join(fields[:last-3], "%20"),
+		IpAddress:   fields[last-3],
+		Date:        fields[last-2],
+		ContentType: fields[last-1],
+		Length:      fields[last],
+	}
+	if length, err := strconv.ParseInt(header.Length, 10, 64); err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid ARC record length %q", header.Length)
+	}
+	if len(header.Date) < 8 || strings.Trim(header.Date, "0123456789") != "" {
+		return nil, fmt.Errorf("invalid ARC archive date %q", header.Date)
+	}
+	if !strings.Contains(header.Url, ":") {
+		return nil, fmt.Errorf("invalid ARC record URL %q", header.Url)
+	}
+	return header, nil
+}
+
+// Checks if the line is the header line of an ARC record
+func isArcHeaderLine(line string) bool {
+	_, err := splitArcHeaderLine(strings.TrimRight(line, "\r"))
+	return err == nil
+}
+
+// Converts the header line of an ARC record to the header of the equivalent WARC record.
+// HTTP captures become response records with an application/http content, as in WARCs,
+// and the content type recorded by the crawler is kept in WARC-Identified-Payload-Type.
+// The filedesc record becomes a warcinfo record.
+func parseArcHeaderLine(line string) (WarcHeader, error) {
+	arcHeader, err := splitArcHeaderLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	header := WarcHeader{}
+	header.Set("WARC-Target-URI", arcHeader.Url)
+	header.Set("WARC-IP-Address", arcHeader.IpAddress)
+	header.Set("WARC-Identified-Payload-Type", arcHeader.ContentType)
+	header.Set("Content-Length", arcHeader.Length)
+
+	// Older crawlers wrote shorter dates, the missing digits are zeros
+	date := arcHeader.Date
+	if len(date) < len(ARC_DATE_LAYOUT) {
+		date += strings.Repeat("0", len(ARC_DATE_LAYOUT)-len(date))
+	}
+	if archiveDate, err := time.Parse(ARC_DATE_LAYOUT, date[:len(ARC_DATE_LAYOUT)]); err == nil {
+		header.Set("WARC-Date", archiveDate.UTC().Format(time.RFC3339))
+	} else {
+		// Left unparsed, the extraction reports the invalid date
+		header.Set("WARC-Date", arcHeader.Date)
+	}
+
+	lowerUrl := strings.ToLower(arcHeader.Url)
+	switch {
+	case strings.HasPrefix(lowerUrl, arcMagic):
+		header.Set("WARC-Type", "warcinfo")
+		header.Set("Content-Type", "text/plain")
+	case strings.HasPrefix(lowerUrl, "http://") || strings.HasPrefix(lowerUrl, "https://"):
+		header.Set("WARC-Type", "response")
+		header.Set("Content-Type", "application/http; msgtype=response")
+	default:
+		header.Set("WARC-Type", "response")
+		header.Set("Content-Type", arcHeader.ContentType)
+	}
+	return header, nil
+}
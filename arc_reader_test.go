@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func testArcRecord(url, date, content string) string {
+	return fmt.Sprintf("%s 192.0.2.1 %s text/html %d\n%s\n", url, date, len(content), content)
+}
+
+func testArcFile(records ...string) []string {
+	description := "1 0 Test Archive\nURL IP-address Archive-date Content-type Archive-length\n\n"
+	fileHeader := fmt.Sprintf("filedesc://test.arc 0.0.0.0 20050101000000 text/plain %d\n%s\n", len(description), description)
+	return append([]string{fileHeader}, records...)
+}
+
+func TestArcReader(t *testing.T) {
+	page := "HTTP/1.0 200 OK\r\nContent-Type: text/html\r\n\r\n<a href=\"/next\">next</a>"
+	records := testArcFile(
+		testArcRecord("http://example.com/", "20050103101112", page),
+		testArcRecord("dns:example.com", "20050103101110", "example.com. 3600 IN A 192.0.2.1"),
+	)
+
+	gzipped, offsets := gzipMembers(records...)
+	plain := strings.Join(records, "")
+	inputs := map[string][]byte{"arc": []byte(plain), "arc.gz": gzipped}
+
+	for name, input := range inputs {
+		reader, err := NewWarcReader(bytes.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := reader.ReadRecord()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if info.Header.Get("WARC-Type") != "warcinfo" || info.Offset != 0 {
+			t.Errorf("%s: unexpected file header %v", name, info.Header)
+		}
+
+		response, err := reader.ReadRecord()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		expected := map[string]string{
+			"WARC-Type":                    "response",
+			"WARC-Target-URI":              "http://example.com/",
+			"WARC-Date":                    "2005-01-03T10:11:12Z",
+			"WARC-IP-Address":              "192.0.2.1",
+			"WARC-Identified-Payload-Type": "text/html",
+			"Content-Type":                 "application/http; msgtype=response",
+		}
+		for key, value := range expected {
+			if response.Header.Get(key) != value {
+				t.Errorf("%s: %s is %q, expected %q", name, key, response.Header.Get(key), value)
+			}
+		}
+		expectedOffset := int64(len(records[0]))
+		if name == "arc.gz" {
+			expectedOffset = offsets[1]
+		}
+		if response.Offset != expectedOffset {
+			t.Errorf("%s: offset %d, expected %d", name, response.Offset, expectedOffset)
+		}
+		content, err := ioutil.ReadAll(response.Content)
+		if err != nil || string(content) != page {
+			t.Errorf("%s: unexpected content %q (%v)", name, content, err)
+		}
+
+		dns, err := reader.ReadRecord()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if dns.Header.Get("Content-Type") != "text/html" || dns.Header.Get("WARC-Target-URI") != "dns:example.com" {
+			t.Errorf("%s: unexpected dns record %v", name, dns.Header)
+		}
+
+		if _, err := reader.ReadRecord(); err != io.EOF {
+			t.Errorf("%s: expected EOF, got %v", name, err)
+		}
+	}
+
+	markers := readTestWarc(t, plain)
+	if len(markers) != 2 || markers[0].Link != "http://example.com/next" || markers[1].Tag != "200" ||
+		markers[1].Date != 1104747072 || markers[1].WarcOffset != int64(len(records[0])) {
+		t.Errorf("unexpected markers %+v", markers)
+	}
+}
+
+func TestArcReaderResync(t *testing.T) {
+	records := testArcFile(
+		"http://example.com/broken 192.0.2.1 20050103101112 text/html length\nHTTP/1.0 200 OK\r\n\r\n\n",
+		testArcRecord("http://example.com/valid", "20050103", "HTTP/1.1 200 OK\r\n\r\n"),
+	)
+	reader, err := NewWarcReader(strings.NewReader(strings.Join(records, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.ReadRecord(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = reader.ReadRecord()
+	if _, ok := err.(*RecordError); !ok {
+		t.Fatalf("expected a RecordError, got %v", err)
+	}
+	if err := reader.Resync(); err != nil {
+		t.Fatal(err)
+	}
+
+	record, targetUri := readTargetUri(t, reader)
+	if targetUri != "http://example.com/valid" || record.Header.Get("WARC-Date") != "2005-01-03T00:00:00Z" {
+		t.Errorf("unexpected record after resync %v", record.Header)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	"reflect"
+)
+
+// Rows of the record batches of the Arrow output
+const ARROW_BATCH_ROWS = 64 * 1024
+
+// Markers written as an Arrow IPC stream of record batches, with the columns of the Parquet schema
+type arrowSink struct {
+	destination string
+	out         *outputFile
+	builder     *array.RecordBuilder
+	writer      *ipc.Writer
+	// Rows in the builder
+	buffered int
+	rows     int64
+	bytes    int64
+}
+
+// Schema of the Arrow output
+func arrowMarkerSchema() *arrow.Schema {
+	fields := make([]arrow.Field, len(markerColumns))
+	for i, column := range markerColumns {
+		fields[i] = arrow.Field{Name: column.name}
+		switch column.kind {
+		case reflect.Int64:
+			fields[i].Type = arrow.PrimitiveTypes.Int64
+		case reflect.Int32:
+			fields[i].Type = arrow.PrimitiveTypes.Int32
+		case reflect.Bool:
+			fields[i].Type = arrow.FixedWidthTypes.Boolean
+		default:
+			fields[i].Type = arrow.BinaryTypes.String
+		}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+func newArrowSink(destination string, httpOptions HttpOptions) (*arrowSink, error) {
+	out, err := createOutputFile(destination, COMPRESSION_NONE, httpOptions)
+	if err != nil {
+		return nil, err
+	}
+	schema := arrowMarkerSchema()
+	allocator := memory.NewGoAllocator()
+	return &arrowSink{
+		destination: destination,
+		out:         out,
+		builder:     array.NewRecordBuilder(allocator, schema),
+		writer:      ipc.NewWriter(out, ipc.WithSchema(schema), ipc.WithAllocator(allocator)),
+	}, nil
+}
+
+func (s *arrowSink) Write(marker Marker) error {
+	value := reflect.ValueOf(&marker).Elem()
+	for i, column := range markerColumns {
+		field := value.Field(column.field)
+		switch builder := s.builder.Field(i).(type) {
+		case *array.Int64Builder:
+			builder.Append(field.Int())
+		case *array.Int32Builder:
+			builder.Append(int32(field.Int()))
+		case *array.BooleanBuilder:
+			builder.Append(field.Bool())
+		case *array.StringBuilder:
+			builder.Append(field.String())
+		}
+	}
+	s.buffered++
+	s.rows++
+	if s.buffered >= ARROW_BATCH_ROWS {
+		return s.flush()
+	}
+	return nil
+}
+
+// Appends the columns of the batch to the builder
+func (s *arrowSink) WriteBatch(batch *MarkerBatch) error {
+	for i, column := range batchColumns(batch) {
+		switch builder := s.builder.Field(i).(type) {
+		case *array.Int64Builder:
+			builder.AppendValues(column.Interface().([]int64), nil)
+		case *array.BooleanBuilder:
+			builder.AppendValues(column.Interface().([]bool), nil)
+		case *array.StringBuilder:
+			builder.AppendValues(column.Interface().([]string), nil)
+		default:
+			for row := 0; row < column.Len(); row++ {
+				builder.(*array.Int32Builder).Append(int32(column.Index(row).Int()))
+			}
+		}
+	}
+	s.buffered += batch.Len()
+	s.rows += int64(batch.Len())
+	if s.buffered >= ARROW_BATCH_ROWS {
+		return s.flush()
+	}
+	return nil
+}
+
+// Writes the rows in the builder as a record batch
+func (s *arrowSink) flush() error {
+	record := s.builder.NewRecord()
+	defer record.Release()
+	s.buffered = 0
+	if err := s.writer.Write(record); err != nil {
+		return &WriterError{Destination: s.destination, Op: "write", Err: err}
+	}
+	return nil
+}
+
+func (s *arrowSink) Close() error {
+	var err error
+	if s.buffered > 0 {
+		err = s.flush()
+	}
+	if closeErr := s.writer.Close(); closeErr != nil && err == nil {
+		err = &WriterError{Destination: s.destination, Op: "finalize", Err: closeErr}
+	}
+	s.builder.Release()
+	if closeErr := s.out.Close(); closeErr != nil && err == nil {
+		err = &WriterError{Destination: s.destination, Op: "finalize", Err: closeErr}
+	}
+	s.bytes = s.out.written()
+	return err
+}
+
+func (s *arrowSink) Stats() OutputStats {
+	return OutputStats{Rows: s.rows, Bytes: s.bytes}
+}
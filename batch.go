@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Input WARC and output file of a single job in batch mode
+type SourceDestination struct {
+	Index           int
+	SourceFile      string
+	DestinationFile string
+}
+
+// Outcome of a single job in batch mode
+type BatchResult struct {
+	SourceDestination
+	Duration time.Duration
+	Stats    OutputStats
+	Err      error
+}
+
+// Processes all the WARC files in the list with a pool of workersCount workers.
+// Each WARC is written to <outputPath>/<warc_name>.<format>, or to the partitions under
+// outputPath if the output is partitioned, and gets its own error log.
+// The results are returned in the same order as the input paths. It fails before starting
+// if two WARCs share the same name, their outputs and logs would overwrite each other.
+func RunBatch(paths []string, outputPath, dataOrigin, errorsPath string, workersCount int, config ExtractionConfig) ([]BatchResult, error) {
+
+	// The partitioned outputs share the root, their files are named after the WARC
+	partitioned := config.Parquet != nil && config.Parquet.Partitioned
+	jobs, err := batchJobs(paths, outputPath, outputExtension(config.outputFormat()), partitioned)
+	if err != nil {
+		return nil, err
+	}
+
+	if workersCount < 1 {
+		workersCount = 1
+	}
+	// The workers share the budget, each one holds its chunks and original captures in its share
+	if config.MemoryBudget != nil {
+		if workersCount < len(jobs) {
+			config.MemoryBudget.shareAmong(workersCount)
+		} else {
+			config.MemoryBudget.shareAmong(len(jobs))
+		}
+	}
+
+	pathsChannel := make(chan SourceDestination)
+	resultsChannel := make(chan BatchResult)
+
+	var workersWaitGroup sync.WaitGroup
+	for w := 1; w <= workersCount; w++ {
+		workersWaitGroup.Add(1)
+		go BatchWorker(dataOrigin, errorsPath, config, pathsChannel, resultsChannel, &workersWaitGroup)
+	}
+
+	go func() {
+		for _, job := range jobs {
+			pathsChannel <- job
+		}
+		close(pathsChannel)
+
+		workersWaitGroup.Wait()
+		close(resultsChannel)
+	}()
+
+	results := make([]BatchResult, len(paths))
+	for result := range resultsChannel {
+		results[result.Index] = result
+	}
+	return results, nil
+}
+
+// Jobs of the WARC paths. The outputs, their partition files and the error logs are named
+// after the WARCs, an error is returned if two paths have the same name.
+func batchJobs(paths []string, outputPath, extension string, partitioned bool) ([]SourceDestination, error) {
+	jobs := make([]SourceDestination, 0, len(paths))
+	names := make(map[string]string, len(paths))
+	for i, sourceWarc := range paths {
+		file := inputName(sourceWarc)
+		if previous, found := names[file]; found {
+			return nil, fmt.Errorf("%s and %s would be written to the same output %s", previous, sourceWarc, file)
+		}
+		names[file] = sourceWarc
+
+		destination := joinOutputPath(outputPath, file+extension)
+		if partitioned {
+			destination = outputPath
+		}
+		jobs = append(jobs, SourceDestination{
+			Index:           i,
+			SourceFile:      sourceWarc,
+			DestinationFile: destination,
+		})
+	}
+	return jobs, nil
+}
+
+// Consumes the jobs from the paths channel until it is closed
+func BatchWorker(dataOrigin, errorsPath string, config ExtractionConfig, pathsChannel chan SourceDestination,
+	resultsChannel chan BatchResult, workersWaitGroup *sync.WaitGroup) {
+
+	defer workersWaitGroup.Done()
+
+	for job := range pathsChannel {
+		start := time.Now()
+		stats, err := processBatchJob(job, dataOrigin, errorsPath, config)
+		resultsChannel <- BatchResult{SourceDestination: job, Duration: time.Now().Sub(start), Stats: stats, Err: err}
+	}
+}
+
+// Runs the extraction of a single WARC. A panic is turned into an error as well
+// so that one broken file does not stop the whole batch
+func processBatchJob(job SourceDestination, dataOrigin, errorsPath string, config ExtractionConfig) (stats OutputStats, err error) {
+
+	logger, err := NewLogger(job.SourceFile, errorsPath, inputName(job.SourceFile))
+	if err != nil {
+		return stats, err
+	}
+	go logger.run()
+	defer logger.quit()
+
+	// The worker stops the reader, the parsers, the collector and the writer before a panic
+	// reaches this point, so none of them logs after the logger quits
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("extraction aborted: %v", r)
+		}
+	}()
+
+	return LinkExtractionWorker(job.SourceFile, job.DestinationFile, dataOrigin, config, logger)
+}
+
+// Prints one line per job and returns the number of failed jobs
+func printBatchSummary(results []BatchResult) int {
+	failed := 0
+	var total OutputStats
+	for _, result := range results {
+		total.add(result.Stats)
+		if result.Err != nil {
+			failed++
+			fmt.Fprintln(os.Stderr, "FAILED", result.SourceFile, "-", result.Err)
+		} else {
+			fmt.Fprintln(os.Stderr, "OK", result.SourceFile, "->", result.DestinationFile, "("+result.Stats.String()+") in", result.Duration)
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Batch completed:", len(results)-failed, "succeeded,", failed, "failed")
+	fmt.Fprintln(os.Stderr, "Output written:", total)
+	return failed
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// Builds a WARC response record wrapping the given HTTP response
+func testWarcResponse(targetUri, httpResponse string) string {
+	return fmt.Sprintf("WARC/1.0\r\n"+
+		"WARC-Type: response\r\n"+
+		"WARC-Date: 2019-07-27T10:00:00Z\r\n"+
+		"WARC-Target-URI: %s\r\n"+
+		"WARC-Record-ID: <urn:uuid:%08x-0000-0000-0000-000000000000>\r\n"+
+		"Content-Type: application/http; msgtype=response\r\n"+
+		"Content-Length: %d\r\n"+
+		"\r\n%s\r\n\r\n", targetUri, len(targetUri), len(httpResponse), httpResponse)
+}
+
+func TestRunBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	page := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<a href=\"/about\">About</a>"
+	warcPath := path.Join(dir, "sample.warc")
+	err = ioutil.WriteFile(warcPath, []byte(testWarcResponse("http://example.com/", page)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.MkdirAll(path.Join(dir, "out"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{warcPath, path.Join(dir, "missing.warc")}
+	results, err := RunBatch(paths, path.Join(dir, "out"), "test", dir+"/", 2, ExtractionConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Err != nil {
+		t.Errorf("unexpected failure for %s: %s", results[0].SourceFile, results[0].Err)
+	}
+	if results[0].DestinationFile != path.Join(dir, "out", "sample.warc.parquet") {
+		t.Errorf("unexpected destination %s", results[0].DestinationFile)
+	}
+	if results[1].Err == nil {
+		t.Errorf("expected a failure for %s", results[1].SourceFile)
+	}
+}
+
+func TestRunBatchDuplicateNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths := []string{"a/x.warc.gz", "b/y.warc.gz", "c/x.warc.gz"}
+	for _, partitioned := range []bool{false, true} {
+		config := ExtractionConfig{Parquet: &ParquetOptions{Partitioned: partitioned}}
+		if _, err := RunBatch(paths, path.Join(dir, "out"), "test", dir+"/", 2, config); err == nil ||
+			!strings.Contains(err.Error(), "a/x.warc.gz and c/x.warc.gz") {
+			t.Errorf("partitioned %v: expected the duplicate names to fail, got %v", partitioned, err)
+		}
+	}
+	// Nothing was started
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+func TestReadLinesGzip(t *testing.T) {
+	file, err := ioutil.TempFile("", "warcs-*.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	writer := gzip.NewWriter(file)
+	writer.Write([]byte("crawl-data/0.warc.gz\ncrawl-data/1.warc.gz\n"))
+	writer.Close()
+	file.Close()
+
+	lines, err := readLines(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[1] != "crawl-data/1.warc.gz" {
+		t.Errorf("unexpected lines %v", lines)
+	}
+}
+
+func TestRunBatchSharedBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Link-heavy pages with a payload digest, their links are kept as original captures
+	const workers, pages, linksPerPage = 4, 40, 400
+	var paths []string
+	for w := 0; w < workers; w++ {
+		var warc strings.Builder
+		for i := 0; i < pages; i++ {
+			var page strings.Builder
+			page.WriteString("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<html>")
+			for j := 0; j < linksPerPage; j++ {
+				fmt.Fprintf(&page, "<a href=\"/section/page-%d-%d.html\">Title</a>\n", i, j)
+			}
+			page.WriteString("</html>")
+			record := testWarcResponse(fmt.Sprintf("http://example%d.com/page-%d", w, i), page.String())
+			warc.WriteString(strings.Replace(record, "WARC-Type: response\r\n",
+				fmt.Sprintf("WARC-Type: response\r\nWARC-Payload-Digest: sha1:PAGE%dX%d\r\n", w, i), 1))
+		}
+		warcPath := path.Join(dir, fmt.Sprintf("part-%d.warc", w))
+		if err := ioutil.WriteFile(warcPath, []byte(warc.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, warcPath)
+	}
+	if err := os.MkdirAll(path.Join(dir, "out"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	budget := NewMemoryBudget(12 * MB)
+	config := ExtractionConfig{Format: FORMAT_JSONL, ParsersCount: 2, CopyRevisitLinks: true, MemoryBudget: budget}
+	results, err := RunBatch(paths, path.Join(dir, "out"), "test", dir+"/", workers, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Err != nil || result.Stats.Rows != pages*(linksPerPage+1) {
+			t.Errorf("%s: %d rows written: %v", result.SourceFile, result.Stats.Rows, result.Err)
+		}
+	}
+
+	// The chunks and the original captures held by the workers are bounded by their shares
+	if budget.Used() != 0 || budget.Peak() > budget.Limit() {
+		t.Errorf("the budget peaked at %d bytes, over its limit of %d, %d bytes are left", budget.Peak(), budget.Limit(), budget.Used())
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"github.com/PuerkitoBio/purell"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Records read after a response before its page marker is released without
+// waiting any longer for the request and metadata records concurrent to it
+const JOIN_WINDOW_RECORDS = 8
+
+// How the crawler reached a page, from the request and metadata records of the response
+type crawlInfo struct {
+	// Referer header of the request
+	Referrer string
+	// URL the page was discovered from and hop path from the seed (Heritrix metadata)
+	Via          string
+	HopsFromSeed string
+}
+
+// Fills the fields still empty with the ones of other
+func (c *crawlInfo) merge(other crawlInfo) {
+	if len(c.Referrer) == 0 {
+		c.Referrer = other.Referrer
+	}
+	if len(c.Via) == 0 {
+		c.Via = other.Via
+	}
+	if len(c.HopsFromSeed) == 0 {
+		c.HopsFromSeed = other.HopsFromSeed
+	}
+}
+
+// Reads the crawl information of a request record
+func readRequestInfo(content io.Reader) (crawlInfo, error) {
+	header, err := ReadHttpRequestHeader(content)
+	if err != nil {
+		return crawlInfo{}, err
+	}
+	return crawlInfo{Referrer: normalizeCrawlUrl(header.Get("Referer"))}, nil
+}
+
+// Reads the crawl information of a metadata record, whose content is a list of
+// "name: value" lines. The outlinks listed by the crawler are skipped. Reading errors are
+// left to the WARC reader, which finds them again consuming the rest of the record.
+func readMetadataInfo(content io.Reader) crawlInfo {
+	var info crawlInfo
+	reader := bufio.NewReader(content)
+	for {
+		line, err := reader.ReadString('\n')
+		if separator := strings.IndexByte(line, ':'); separator > 0 {
+			value := strings.TrimSpace(line[separator+1:])
+			switch strings.ToLower(strings.TrimSpace(line[:separator])) {
+			case "via":
+				info.Via = normalizeCrawlUrl(value)
+			case "hopsfromseed":
+				info.HopsFromSeed = toValidUTF8(value)
+			}
+		}
+		if err != nil {
+			return info
+		}
+	}
+}
+
+// Normalizes a URL of the crawl information like the page URLs, it is kept as it is if it cannot be parsed
+func normalizeCrawlUrl(rawUrl string) string {
+	rawUrl = sanitizeString(rawUrl)
+	if len(rawUrl) == 0 {
+		return ""
+	}
+	if parsedUrl, err := url.Parse(rawUrl); err == nil {
+		return purell.NormalizeURL(parsedUrl, PURELL_FLAGS)
+	}
+	return toValidUTF8(rawUrl)
+}
+
+// Markers of a response waiting for its request and metadata records
+type pendingPage struct {
+	ids []string
+	// Markers of the headers, links of the page and page marker, in the order they are released
+	markers []Marker
+	links   *MarkerBatch
+	page    *Marker
+	info    crawlInfo
+	read    int
+	// Bytes of the record held in the memory budget
+	size int64
+}
+
+// Crawl information of a request or metadata record read before its response
+type pendingInfo struct {
+	info crawlInfo
+	read int
+}
+
+// Joins the request and metadata records to their response, which are concurrent
+// records: the request or metadata refers to the response with WARC-Concurrent-To
+// (Heritrix), or the response refers to the request (Common Crawl). The records can
+// come in any order, so the markers of a response are kept until JOIN_WINDOW_RECORDS
+// more records are read, and then released in the order of the responses.
+type recordJoiner struct {
+	pages []*pendingPage
+	// Pending pages by record ID and concurrent record IDs
+	pagesById map[string]*pendingPage
+	infosById map[string]*pendingInfo
+	read      int
+}
+
+func newRecordJoiner() *recordJoiner {
+	return &recordJoiner{pagesById: map[string]*pendingPage{}, infosById: map[string]*pendingInfo{}}
+}
+
+// IDs a record can be joined by: its own and the ones it is concurrent to
+func joinIds(header WarcHeader) []string {
+	var ids []string
+	for _, key := range []string{"WARC-Record-ID", "WARC-Concurrent-To"} {
+		if id := header.Get(key); len(id) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Adds the markers of a response, the links can be nil. The batch of the links is
+// given back to the pool when the markers are released, size is the bytes of the
+// record held in the memory budget until then.
+func (j *recordJoiner) addPage(header WarcHeader, markers []Marker, links *MarkerBatch, page *Marker, size int64) {
+	pending := &pendingPage{ids: joinIds(header), markers: markers, links: links, page: page, read: j.read, size: size}
+	for _, id := range pending.ids {
+		if info, found := j.infosById[id]; found {
+			pending.info.merge(info.info)
+			delete(j.infosById, id)
+		}
+		j.pagesById[id] = pending
+	}
+	j.pages = append(j.pages, pending)
+}
+
+// Adds the crawl information of a request or metadata record
+func (j *recordJoiner) addInfo(header WarcHeader, info crawlInfo) {
+	ids := joinIds(header)
+	for _, id := range ids {
+		if pending, found := j.pagesById[id]; found {
+			pending.info.merge(info)
+			return
+		}
+	}
+	for _, id := range ids {
+		if previous, found := j.infosById[id]; found {
+			previous.info.merge(info)
+		} else {
+			j.infosById[id] = &pendingInfo{info: info, read: j.read}
+		}
+	}
+}
+
+// Counts a record read and moves the markers of the responses out of the window to the batch.
+// It returns the bytes of the records released.
+func (j *recordJoiner) next(markersBuffer *MarkerBatch) int64 {
+	j.read++
+	releasedSize := j.release(markersBuffer, j.read-JOIN_WINDOW_RECORDS)
+
+	for id, pending := range j.infosById {
+		if pending.read < j.read-JOIN_WINDOW_RECORDS {
+			delete(j.infosById, id)
+		}
+	}
+	return releasedSize
+}
+
+// Moves the markers of all the pending responses to the batch, it returns the bytes of their records
+func (j *recordJoiner) flush(markersBuffer *MarkerBatch) int64 {
+	releasedSize := j.release(markersBuffer, j.read+1)
+	j.infosById = map[string]*pendingInfo{}
+	return releasedSize
+}
+
+// Moves the markers of the responses read before the given record count to the batch,
+// it returns the bytes of their records
+func (j *recordJoiner) release(markersBuffer *MarkerBatch, before int) int64 {
+	released := 0
+	var releasedSize int64
+	for _, pending := range j.pages {
+		if pending.read >= before {
+			break
+		}
+		pending.page.Referrer = pending.info.Referrer
+		pending.page.Via = pending.info.Via
+		pending.page.HopsFromSeed = pending.info.HopsFromSeed
+		for i := range pending.markers {
+			markersBuffer.Append(&pending.markers[i])
+		}
+		if pending.links != nil {
+			markersBuffer.AppendBatch(pending.links)
+			pending.links.Release()
+		}
+		markersBuffer.Append(pending.page)
+		releasedSize += pending.size
+		released++
+	}
+	if released == 0 {
+		return 0
+	}
+	for _, pending := range j.pages[:released] {
+		for _, id := range pending.ids {
+			if j.pagesById[id] == pending {
+				delete(j.pagesById, id)
+			}
+		}
+	}
+	j.pages = j.pages[released:]
+	return releasedSize
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testWarcRecord(recordType, id, concurrentTo, contentType, content string) string {
+	header := "WARC/1.0\r\n" +
+		"WARC-Type: " + recordType + "\r\n" +
+		"WARC-Date: 2019-07-27T10:00:00Z\r\n" +
+		"WARC-Target-URI: http://example.com/page\r\n" +
+		"WARC-Record-ID: " + id + "\r\n"
+	if len(concurrentTo) > 0 {
+		header += "WARC-Concurrent-To: " + concurrentTo + "\r\n"
+	}
+	return fmt.Sprintf("%sContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s\r\n\r\n", header, contentType, len(content), content)
+}
+
+func TestRecordJoins(t *testing.T) {
+	response := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<a href=\"/next\">next</a>"
+	request := "GET /page HTTP/1.1\r\nHost: example.com\r\nReferer: http://Example.com/from/\r\n\r\n"
+	metadata := "via: http://example.com/via\r\nhopsFromSeed: LLR\r\noutlink: http://example.com/next L a/@href\r\n"
+
+	// Heritrix writes the response first, the other records refer to it
+	heritrix := testWarcRecord("response", "<urn:1>", "", "application/http; msgtype=response", response) +
+		testWarcRecord("request", "<urn:2>", "<urn:1>", "application/http; msgtype=request", request) +
+		testWarcRecord("metadata", "<urn:3>", "<urn:1>", "application/warc-fields", metadata)
+	// Common Crawl writes the request first and the response refers to it
+	commonCrawl := testWarcRecord("request", "<urn:2>", "", "application/http; msgtype=request", request) +
+		testWarcRecord("response", "<urn:1>", "<urn:2>", "application/http; msgtype=response", response) +
+		testWarcRecord("metadata", "<urn:3>", "<urn:1>", "application/warc-fields", metadata)
+
+	for name, warc := range map[string]string{"heritrix": heritrix, "common crawl": commonCrawl} {
+		markers := readTestWarc(t, warc)
+		if len(markers) != 2 || markers[0].Link != "http://example.com/next" {
+			t.Fatalf("%s: unexpected markers %v", name, markers)
+		}
+		page := markers[1]
+		if page.Referrer != "http://example.com/from" || page.Via != "http://example.com/via" || page.HopsFromSeed != "LLR" {
+			t.Errorf("%s: unexpected page marker %+v", name, page)
+		}
+	}
+
+	// The metadata is too far from the response, the order of the markers is kept
+	var warc strings.Builder
+	warc.WriteString(testWarcRecord("response", "<urn:1>", "", "application/http; msgtype=response", response))
+	for i := 0; i <= JOIN_WINDOW_RECORDS; i++ {
+		warc.WriteString(testWarcRecord("response", fmt.Sprintf("<urn:other-%d>", i), "",
+			"application/http; msgtype=response", "HTTP/1.1 404 Not Found\r\n\r\n"))
+	}
+	warc.WriteString(testWarcRecord("metadata", "<urn:3>", "<urn:1>", "application/warc-fields", metadata))
+
+	markers := readTestWarc(t, warc.String())
+	if len(markers) != JOIN_WINDOW_RECORDS+3 {
+		t.Fatalf("unexpected markers %v", markers)
+	}
+	if markers[1].Tag != "200" || markers[1].Via != "" {
+		t.Errorf("unexpected page marker %+v", markers[1])
+	}
+	for _, page := range markers[2:] {
+		if page.Tag != "404" {
+			t.Errorf("unexpected page marker %+v", page)
+		}
+	}
+}
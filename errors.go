@@ -0,0 +1,52 @@
+package main
+
+import "fmt"
+
+// Returned when the input WARC cannot be opened or read as a WARC
+type InputError struct {
+	Path string
+	Err  error
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("input %s: %s", e.Path, e.Err)
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// Returned when the reader fails to process a record of the WARC.
+// RecordID is empty if the failure happened before parsing the record header.
+type RecordError struct {
+	Offset   int64
+	RecordID string
+	Err      error
+}
+
+func (e *RecordError) Error() string {
+	if e.RecordID != "" {
+		return fmt.Sprintf("malformed record %s at offset %d: %s", e.RecordID, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("malformed record at offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// Returned when the output cannot be created, written or finalized.
+// Op is one of "create", "write" or "finalize".
+type WriterError struct {
+	Destination string
+	Op          string
+	Err         error
+}
+
+func (e *WriterError) Error() string {
+	return fmt.Sprintf("output %s (%s): %s", e.Destination, e.Op, e.Err)
+}
+
+func (e *WriterError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"golang.org/x/net/html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Maximum length of the Extras of a link marker
+const MAX_EXTRAS_LENGTH = 256
+
+// Attribute of an HTML element holding a link
+type linkAttribute struct {
+	// Attribute with the URL
+	urlKey string
+	// Attribute copied in the Extras of the marker
+	extrasKey string
+	// Fixed Extras, used when extrasKey is empty
+	extras string
+	// The attribute is a srcset: a list of URLs with their descriptor
+	srcset bool
+	// The element is a hyperlink, its rel, target, title and hreflang are copied in the marker
+	hyperlink bool
+}
+
+// Elements, other than the anchors and <link>, whose attributes are links to other pages or resources
+var linkAttributes = map[string][]linkAttribute{
+	"area":   {{urlKey: "href", extrasKey: "alt", hyperlink: true}},
+	"form":   {{urlKey: "action", extrasKey: "method"}},
+	"script": {{urlKey: "src", extrasKey: "type"}},
+
+	// Embedded resources
+	"img":    {{urlKey: "src", extrasKey: "alt"}, {urlKey: "srcset", srcset: true}},
+	"source": {{urlKey: "src", extrasKey: "type"}, {urlKey: "srcset", srcset: true}},
+	"iframe": {{urlKey: "src", extrasKey: "title"}},
+	"embed":  {{urlKey: "src", extrasKey: "type"}},
+	"object": {{urlKey: "data", extrasKey: "type"}},
+	"video":  {{urlKey: "src", extras: "src"}, {urlKey: "poster", extras: "poster"}},
+	"audio":  {{urlKey: "src", extras: "src"}},
+	"track":  {{urlKey: "src", extrasKey: "kind"}},
+}
+
+// URL of a srcset and its width or density descriptor (e.g. "480w" or "2x")
+type srcsetCandidate struct {
+	URL        string
+	Descriptor string
+}
+
+// Splits a srcset attribute in its image candidates following the parsing rules of the HTML standard
+func parseSrcset(srcset string) []srcsetCandidate {
+	var candidates []srcsetCandidate
+
+	position := 0
+	for position < len(srcset) {
+		// Skip the separators
+		for position < len(srcset) && (isHtmlSpace(srcset[position]) || srcset[position] == ',') {
+			position++
+		}
+		if position >= len(srcset) {
+			break
+		}
+
+		start := position
+		for position < len(srcset) && !isHtmlSpace(srcset[position]) {
+			position++
+		}
+		url := srcset[start:position]
+
+		if strings.HasSuffix(url, ",") {
+			// No descriptors
+			candidates = append(candidates, srcsetCandidate{URL: strings.TrimRight(url, ",")})
+			continue
+		}
+
+		// The descriptors end at the first comma outside parentheses
+		start = position
+		inParens := false
+		for position < len(srcset) {
+			c := srcset[position]
+			if c == '(' {
+				inParens = true
+			} else if c == ')' {
+				inParens = false
+			} else if c == ',' && !inParens {
+				break
+			}
+			position++
+		}
+		descriptor := strings.Join(strings.FieldsFunc(srcset[start:position], unicode.IsSpace), " ")
+		candidates = append(candidates, srcsetCandidate{URL: url, Descriptor: descriptor})
+	}
+	return candidates
+}
+
+// Normalizes a rel attribute: the tokens are lower case, sorted and without duplicates
+func normalizeRel(rel string) string {
+	tokens := strings.Fields(strings.ToLower(rel))
+	sort.Strings(tokens)
+	unique := tokens[:0]
+	for _, token := range tokens {
+		if len(unique) == 0 || token != unique[len(unique)-1] {
+			unique = append(unique, token)
+		}
+	}
+	return strings.Join(unique, " ")
+}
+
+// Checks if the normalized rel contains the token
+func hasRelToken(rel string, token string) bool {
+	for _, relToken := range strings.Fields(rel) {
+		if relToken == token {
+			return true
+		}
+	}
+	return false
+}
+
+// Copies the rel, target, title and hreflang attributes of a hyperlink (<a> or <area>) in its marker
+func setHyperlinkAttributes(link *Marker, attributes []html.Attribute) {
+	for _, attr := range attributes {
+		switch attr.Key {
+		case "rel":
+			link.Rel = normalizeRel(toValidUTF8(attr.Val))
+		case "target":
+			link.Target = toValidUTF8(strings.TrimSpace(attr.Val))
+		case "title":
+			link.Title = toValidUTF8(truncateExtras(strings.TrimSpace(attr.Val)))
+		case "hreflang":
+			link.Hreflang = strings.ToLower(strings.TrimSpace(toValidUTF8(attr.Val)))
+		}
+	}
+}
+
+func isHtmlSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// Cuts the Extras to MAX_EXTRAS_LENGTH bytes
+func truncateExtras(extras string) string {
+	if len(extras) > MAX_EXTRAS_LENGTH {
+		return extras[:MAX_EXTRAS_LENGTH]
+	}
+	return extras
+}
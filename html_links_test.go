@@ -0,0 +1,44 @@
+package main
+
+import (
+	"golang.org/x/net/html"
+	"reflect"
+	"testing"
+)
+
+func TestParseSrcset(t *testing.T) {
+	cases := map[string][]srcsetCandidate{
+		"image.jpg": {{URL: "image.jpg"}},
+		"small.jpg 480w, large.jpg 1080w": {
+			{URL: "small.jpg", Descriptor: "480w"},
+			{URL: "large.jpg", Descriptor: "1080w"},
+		},
+		"a.png, b.png 2x": {{URL: "a.png"}, {URL: "b.png", Descriptor: "2x"}},
+		// Commas inside the URL do not split the candidates
+		"a.png,b.png 2x": {{URL: "a.png,b.png", Descriptor: "2x"}},
+		" data:image/png;base64,AAA= 1x ,\n\t/c,d.png\n2x": {
+			{URL: "data:image/png;base64,AAA=", Descriptor: "1x"},
+			{URL: "/c,d.png", Descriptor: "2x"},
+		},
+		"x.png (a, b) 1x, y.png": {{URL: "x.png", Descriptor: "(a, b) 1x"}, {URL: "y.png"}},
+		"":                       nil,
+	}
+
+	for srcset, expected := range cases {
+		if candidates := parseSrcset(srcset); !reflect.DeepEqual(candidates, expected) {
+			t.Errorf("%q: got %v, expected %v", srcset, candidates, expected)
+		}
+	}
+}
+
+func TestSetHyperlinkAttributes(t *testing.T) {
+	var link Marker
+	setHyperlinkAttributes(&link, []html.Attribute{
+		{Key: "rel", Val: "NoFollow \xffsponsored nofollow"},
+		{Key: "hreflang", Val: " EN-\xfeGB "},
+		{Key: "target", Val: "_blank"},
+	})
+	if link.Rel != "nofollow sponsored" || link.Hreflang != "en-gb" || link.Target != "_blank" {
+		t.Errorf("unexpected attributes %q %q %q", link.Rel, link.Hreflang, link.Target)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default number of consecutive failed requests retried before giving up on an HTTP input
+const DEFAULT_HTTP_RETRIES = 5
+
+// Default wait before the first retry, doubled at each following one up to MAX_HTTP_BACKOFF
+const DEFAULT_HTTP_BACKOFF = time.Second
+const MAX_HTTP_BACKOFF = time.Minute
+
+var errInputChanged = errors.New("the input changed while reading it")
+
+// Options of the inputs fetched over HTTP(S), the retries apply to the S3 requests too
+type HttpOptions struct {
+	// Prefix of the input paths that are not URLs, e.g. https://data.commoncrawl.org/
+	// for the crawl-relative paths of the Common Crawl lists. Ignored if empty.
+	UrlPrefix string
+	// Consecutive failed requests, or dropped connections, retried before giving up
+	Retries int
+	Backoff time.Duration
+	// The default client is used if it is nil
+	Client *http.Client
+}
+
+func DefaultHttpOptions() HttpOptions {
+	return HttpOptions{Retries: DEFAULT_HTTP_RETRIES, Backoff: DEFAULT_HTTP_BACKOFF}
+}
+
+// Checks if the input path is an HTTP(S) URL
+func isHttpUrl(inputPath string) bool {
+	return strings.HasPrefix(inputPath, "http://") || strings.HasPrefix(inputPath, "https://")
+}
+
+// URL of the input, the path prefixed with the URL prefix unless it is already an HTTP(S)
+// or S3 URL, or the standard input. It is the path itself without a prefix.
+func resolveInput(inputPath, urlPrefix string) string {
+	if len(urlPrefix) == 0 || inputPath == STDIN_PATH || isHttpUrl(inputPath) || isS3Url(inputPath) {
+		return inputPath
+	}
+	return strings.TrimRight(urlPrefix, "/") + "/" + strings.TrimLeft(inputPath, "/")
+}
+
+// Body of an HTTP(S) input. When the connection drops the input is requested again from
+// the offset reached with a range request, after an exponential back-off. The ETag of the
+// first response guards the resumed requests against a resource modified in the meantime.
+type httpInput struct {
+	url     string
+	options HttpOptions
+	// Adds the credentials to the requests if not nil
+	authorize func(request *http.Request)
+	body      io.ReadCloser
+	// Bytes of the input already read
+	offset int64
+	// Length of the input, -1 if the server did not tell
+	size int64
+	etag string
+	// Consecutive failures without reading any byte
+	failures int
+	lastErr  error
+	err      error
+}
+
+// Requests the input, the errors of the first request are returned here.
+// authorize adds the credentials to each request, it can be nil.
+func openHttpInput(inputUrl string, options HttpOptions, authorize func(request *http.Request)) (*httpInput, error) {
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	input := &httpInput{url: inputUrl, options: options, authorize: authorize, size: -1}
+	if err := input.connect(); err != nil {
+		return nil, err
+	}
+	return input, nil
+}
+
+func (h *httpInput) Read(p []byte) (int, error) {
+	if h.err != nil {
+		return 0, h.err
+	}
+	for {
+		if h.body == nil {
+			if err := h.connect(); err != nil {
+				h.err = err
+				return 0, err
+			}
+		}
+
+		n, err := h.body.Read(p)
+		h.offset += int64(n)
+		if n > 0 {
+			h.failures = 0
+		}
+		if err != nil && h.size >= 0 {
+			// A connection dropped after the last byte does not need another request
+			if h.offset >= h.size {
+				err = io.EOF
+			} else if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		// The connection dropped, the next request resumes from the offset
+		h.body.Close()
+		h.body = nil
+		h.failures++
+		h.lastErr = err
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Requests the input from the offset, retrying with exponential back-off
+func (h *httpInput) connect() error {
+	for {
+		if h.failures > h.options.Retries {
+			return fmt.Errorf("%s: giving up after %d retries: %s", h.url, h.options.Retries, h.lastErr)
+		}
+		if h.failures > 0 {
+			time.Sleep(h.backoff())
+		}
+		retry, err := h.request()
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return fmt.Errorf("%s: %s", h.url, err)
+		}
+		h.failures++
+		h.lastErr = err
+	}
+}
+
+// Wait before the next retry
+func (h *httpInput) backoff() time.Duration {
+	wait := h.options.Backoff
+	for i := 1; i < h.failures && wait < MAX_HTTP_BACKOFF; i++ {
+		wait *= 2
+	}
+	if wait > MAX_HTTP_BACKOFF {
+		wait = MAX_HTTP_BACKOFF
+	}
+	return wait
+}
+
+// Sends a request for the input from the offset. It returns whether the request can be
+// retried when it fails.
+func (h *httpInput) request() (bool, error) {
+	request, err := http.NewRequest(http.MethodGet, h.url, nil)
+	if err != nil {
+		return false, err
+	}
+	// Otherwise the client asks for gzip and decodes it transparently: the offsets would count
+	// the decoded bytes, not the ones of the ranges, and the length would be unknown
+	request.Header.Set("Accept-Encoding", "identity")
+	if h.offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", h.offset))
+		// Weak validators are not allowed in If-Range
+		if len(h.etag) > 0 && !strings.HasPrefix(h.etag, "W/") {
+			request.Header.Set("If-Range", h.etag)
+		}
+	}
+	if h.authorize != nil {
+		h.authorize(request)
+	}
+
+	response, err := h.options.Client.Do(request)
+	if err != nil {
+		return true, err
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		if h.offset == 0 {
+			h.etag = response.Header.Get("ETag")
+			h.size = response.ContentLength
+			break
+		}
+		// The range was ignored, or the input was modified and sent whole
+		if response.Header.Get("ETag") != h.etag || response.ContentLength >= 0 && response.ContentLength != h.size {
+			response.Body.Close()
+			return false, errInputChanged
+		}
+		if _, err := io.CopyN(ioutil.Discard, response.Body, h.offset); err != nil {
+			response.Body.Close()
+			return true, err
+		}
+	case http.StatusPartialContent:
+		start, err := contentRangeStart(response.Header.Get("Content-Range"))
+		if err != nil || start != h.offset {
+			response.Body.Close()
+			return false, fmt.Errorf("unexpected Content-Range %q for offset %d", response.Header.Get("Content-Range"), h.offset)
+		}
+	default:
+		response.Body.Close()
+		retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests ||
+			response.StatusCode == http.StatusRequestTimeout
+		return retry, fmt.Errorf("HTTP status %s", response.Status)
+	}
+
+	h.body = response.Body
+	return false, nil
+}
+
+// First byte of a Content-Range header like "bytes 100-199/200"
+func contentRangeStart(contentRange string) (int64, error) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	byteRange := contentRange[len("bytes "):]
+	if dash := strings.IndexByte(byteRange, '-'); dash >= 0 {
+		byteRange = byteRange[:dash]
+	}
+	return strconv.ParseInt(strings.TrimSpace(byteRange), 10, 64)
+}
+
+func (h *httpInput) Close() error {
+	h.err = errors.New("input closed")
+	if h.body != nil {
+		err := h.body.Close()
+		h.body = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Response writer aborting the response after limit bytes of the body, like a dropped connection
+type droppingWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *droppingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		w.ResponseWriter.Write(p[:w.limit])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.limit -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// File server of the content. The first responses fail with the statuses, then the next
+// drops responses are dropped after dropAfter bytes of the body. It records the Range
+// headers of the requests. If gzipEncoded the content is sent gzipped to the clients
+// accepting it, the ranges then count the compressed bytes.
+type testHttpServer struct {
+	content     []byte
+	etag        string
+	statuses    []int
+	drops       int
+	dropAfter   int
+	gzipEncoded bool
+
+	mutex  sync.Mutex
+	ranges []string
+}
+
+func (s *testHttpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	var status int
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	drop := s.drops > 0
+	if drop && status == 0 {
+		s.drops--
+	}
+	s.mutex.Unlock()
+
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if len(s.etag) > 0 {
+		w.Header().Set("ETag", s.etag)
+	}
+	content := s.content
+	if s.gzipEncoded && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(content)
+		writer.Close()
+		content = compressed.Bytes()
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	if drop {
+		w = &droppingWriter{ResponseWriter: w, limit: s.dropAfter}
+	}
+	http.ServeContent(w, r, "test.warc", time.Time{}, bytes.NewReader(content))
+}
+
+func (s *testHttpServer) requests() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.ranges...)
+}
+
+func testHttpOptions(retries int) HttpOptions {
+	return HttpOptions{Retries: retries, Backoff: time.Millisecond}
+}
+
+func TestHttpInputResume(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10000))
+	handler := &testHttpServer{content: content, etag: `"v1"`, drops: 3, dropAfter: 10000}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	input, err := openInput(server.URL+"/test.warc", testHttpOptions(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	read, err := ioutil.ReadAll(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("read %d bytes, expected %d", len(read), len(content))
+	}
+
+	// Each request resumes where the previous one was dropped
+	ranges := handler.requests()
+	expected := []string{"", "bytes=10000-", "bytes=20000-", "bytes=30000-"}
+	if strings.Join(ranges, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected ranges %q, expected %q", ranges, expected)
+	}
+}
+
+func TestHttpInputIdentityEncoding(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	content := make([]byte, 100000)
+	for i := range content {
+		content[i] = byte('a' + random.Intn(4))
+	}
+	handler := &testHttpServer{content: content, etag: `"v1"`, gzipEncoded: true, drops: 1, dropAfter: 10000}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	input, err := openHttpInput(server.URL+"/test.warc", testHttpOptions(2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	if input.size != int64(len(content)) {
+		t.Errorf("expected the length %d of the content, got %d", len(content), input.size)
+	}
+	read, err := ioutil.ReadAll(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, content) {
+		t.Errorf("read %d bytes differing from the %d of the content", len(read), len(content))
+	}
+	if ranges := handler.requests(); len(ranges) != 2 || ranges[1] != "bytes=10000-" {
+		t.Errorf("unexpected ranges %q", ranges)
+	}
+}
+
+func TestHttpInputRetries(t *testing.T) {
+	content := []byte("WARC content")
+	handler := &testHttpServer{content: content, statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	input, err := openInput(server.URL+"/test.warc", testHttpOptions(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, err := ioutil.ReadAll(input); err != nil || !bytes.Equal(read, content) {
+		t.Errorf("unexpected content %q: %v", read, err)
+	}
+	input.Close()
+	if requests := len(handler.requests()); requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+
+	// Too many failures
+	handler = &testHttpServer{content: content, statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}}
+	server = httptest.NewServer(handler)
+	defer server.Close()
+	if _, err := openInput(server.URL+"/test.warc", testHttpOptions(2)); err == nil || !strings.Contains(err.Error(), "giving up") {
+		t.Errorf("expected the input to fail after 2 retries, got %v", err)
+	}
+
+	// Too many drops in a row without progress
+	handler = &testHttpServer{content: content, drops: 4, dropAfter: 0}
+	server = httptest.NewServer(handler)
+	defer server.Close()
+	input, err = openInput(server.URL+"/test.warc", testHttpOptions(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(input); err == nil {
+		t.Errorf("expected the input to fail after 2 retries")
+	}
+	input.Close()
+
+	// Not retried
+	handler = &testHttpServer{content: content, statuses: []int{http.StatusNotFound}}
+	server = httptest.NewServer(handler)
+	defer server.Close()
+	if _, err := openInput(server.URL+"/test.warc", testHttpOptions(2)); err == nil {
+		t.Errorf("expected a missing input to fail")
+	}
+	if requests := len(handler.requests()); requests != 1 {
+		t.Errorf("expected a single request, got %d", requests)
+	}
+}
+
+func TestHttpInputChanged(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	handler := &testHttpServer{content: content, etag: `"v1"`, drops: 1, dropAfter: 1000}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	input, err := openInput(server.URL+"/test.warc", testHttpOptions(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	handler.mutex.Lock()
+	handler.etag = `"v2"`
+	handler.mutex.Unlock()
+
+	if _, err := ioutil.ReadAll(input); err == nil || !strings.Contains(err.Error(), errInputChanged.Error()) {
+		t.Errorf("expected the modified input to fail, got %v", err)
+	}
+}
+
+func TestResolveInput(t *testing.T) {
+	for _, test := range []struct {
+		path, prefix, resolved, name string
+	}{
+		{"crawl-data/a.warc.gz", "", "crawl-data/a.warc.gz", "a.warc.gz"},
+		{"crawl-data/a.warc.gz", "https://data.example.org/", "https://data.example.org/crawl-data/a.warc.gz", "a.warc.gz"},
+		{"/crawl-data/a.warc.gz", "https://data.example.org", "https://data.example.org/crawl-data/a.warc.gz", "a.warc.gz"},
+		{"http://other.example.org/b.warc.gz?signature=x", "https://data.example.org/", "http://other.example.org/b.warc.gz?signature=x", "b.warc.gz"},
+		{STDIN_PATH, "https://data.example.org/", STDIN_PATH, "stdin"},
+	} {
+		resolved := resolveInput(test.path, test.prefix)
+		if resolved != test.resolved {
+			t.Errorf("%s: resolved as %s, expected %s", test.path, resolved, test.resolved)
+		}
+		if name := inputName(resolved); name != test.name {
+			t.Errorf("%s: named %s, expected %s", test.path, name, test.name)
+		}
+	}
+}
+
+func TestLinkExtractionWorkerHttp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := newTestLogger(t, dir)
+	defer logger.quit()
+
+	page := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<a href=\"/about\">About</a>"
+	data, offsets := gzipMembers(testWarcResponse("http://example.com/", page), testWarcResponse("http://example.com/other", page))
+	// The connection drops in the middle of the second member
+	handler := &testHttpServer{content: data, drops: 1, dropAfter: int(offsets[1]) + 10}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	config := ExtractionConfig{Format: FORMAT_JSONL, Http: &HttpOptions{UrlPrefix: server.URL + "/crawl-data/", Retries: 1, Backoff: time.Millisecond}}
+	stats, err := LinkExtractionWorker("test.warc.gz", path.Join(dir, "out.jsonl"), "test", config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two pages and their links
+	if stats.Rows != 4 {
+		t.Errorf("expected 4 rows written, got %d", stats.Rows)
+	}
+	if requests := len(handler.requests()); requests != 2 {
+		t.Errorf("expected the dropped connection to be resumed, got %d requests", requests)
+	}
+	content, err := ioutil.ReadFile(path.Join(dir, "out.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The markers reference the path of the list, and the members of their records
+	if strings.Count(string(content), `"warc_file":"test.warc.gz","warc_offset":0,`) != 2 ||
+		strings.Count(string(content), `"warc_file":"test.warc.gz","warc_offset":`+strconv.FormatInt(offsets[1], 10)+`,`) != 2 {
+		t.Errorf("unexpected output %s", content)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+// Maximum size of the header of an HTTP response
+const MAX_HTTP_HEADER_SIZE = 1024 * 1024
+
+var errHttpHeaderTooLarge = errors.New("HTTP header too large")
+
+// HTTP response stored in the payload of a WARC response record
+type HttpResponse struct {
+	// Status code as found in the status line, e.g. "200"
+	StatusCode string
+	// Header fields, the lookup with Get is case insensitive
+	Header http.Header
+	// Body without transfer and content encodings
+	Body io.Reader
+}
+
+// Parses the HTTP response in the payload. The parser is lenient with what is usually found
+// in web archives: malformed header lines are skipped, folded lines are joined, and the
+// transfer and content encodings are only decoded if the body actually looks encoded.
+func ReadHttpResponse(payload io.Reader) (*HttpResponse, error) {
+	reader := bufio.NewReader(payload)
+	response, err := ReadHttpResponseHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	response.Body = response.DecodeBody(reader)
+	return response, nil
+}
+
+// Parses the status line and the header of the HTTP response, the reader is left at the
+// beginning of the body. The Body of the returned response is nil.
+func ReadHttpResponseHeader(reader *bufio.Reader) (*HttpResponse, error) {
+	statusLine, err := readHeaderLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(statusLine, "HTTP/") {
+		return nil, fmt.Errorf("malformed status line %q", statusLine)
+	}
+	response := &HttpResponse{}
+	statusFields := strings.Fields(statusLine)
+	if len(statusFields) > 1 && len(statusFields[1]) >= 3 {
+		response.StatusCode = statusFields[1][:3]
+	}
+
+	response.Header, err = readHttpHeader(reader, len(statusLine))
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Removes the transfer and content encodings declared in the header from the raw body
+func (response *HttpResponse) DecodeBody(body *bufio.Reader) io.Reader {
+	decoded := io.Reader(body)
+	if strings.Contains(strings.ToLower(response.Header.Get("Transfer-Encoding")), "chunked") {
+		decoded = newChunkedBodyReader(body)
+	}
+	return decodeContent(decoded, response.Header.Values("Content-Encoding"))
+}
+
+// Parses the header of the HTTP request in the payload of a WARC request record,
+// with the same leniency of ReadHttpResponse. The body is ignored.
+func ReadHttpRequestHeader(payload io.Reader) (http.Header, error) {
+	reader := bufio.NewReader(payload)
+
+	requestLine, err := readHeaderLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(strings.Fields(requestLine)) < 2 {
+		return nil, fmt.Errorf("malformed request line %q", requestLine)
+	}
+	return readHttpHeader(reader, len(requestLine))
+}
+
+// Reads the header fields till the empty line, skipping the malformed ones.
+// The size of the start line counts in the limit of the header size.
+func readHttpHeader(reader *bufio.Reader, headerSize int) (http.Header, error) {
+	header := http.Header{}
+	var lastKey string
+	for {
+		line, err := readHeaderLine(reader)
+		if err == io.EOF && len(line) == 0 {
+			// No body
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		headerSize += len(line)
+		if headerSize > MAX_HTTP_HEADER_SIZE {
+			return nil, errHttpHeaderTooLarge
+		}
+		if len(line) == 0 {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if lastKey != "" {
+				values := header[lastKey]
+				values[len(values)-1] += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+		separator := strings.IndexByte(line, ':')
+		if separator < 1 {
+			// Malformed line, skip it
+			lastKey = ""
+			continue
+		}
+		lastKey = http.CanonicalHeaderKey(strings.TrimSpace(line[:separator]))
+		header[lastKey] = append(header[lastKey], strings.TrimSpace(line[separator+1:]))
+	}
+	return header, nil
+}
+
+// Reads a header line without the line terminator
+func readHeaderLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Decodes the chunked transfer encoding if the body starts with a chunk size,
+// otherwise the body is returned as it is
+func newChunkedBodyReader(reader *bufio.Reader) io.Reader {
+	line, _ := reader.Peek(18)
+	end := bytes.IndexAny(line, ";\r\n")
+	if end < 1 {
+		return reader
+	}
+	for _, c := range bytes.TrimSpace(line[:end]) {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", rune(c)) {
+			return reader
+		}
+	}
+	return httputil.NewChunkedReader(reader)
+}
+
+// Removes the content encodings in the reverse order they were applied
+func decodeContent(body io.Reader, contentEncodings []string) io.Reader {
+	var encodings []string
+	for _, value := range contentEncodings {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding != "" && encoding != "identity" {
+				encodings = append(encodings, encoding)
+			}
+		}
+	}
+
+	for i := len(encodings) - 1; i >= 0; i-- {
+		buffered := bufio.NewReader(body)
+		body = buffered
+		magic, _ := buffered.Peek(2)
+
+		switch encodings[i] {
+		case "gzip", "x-gzip":
+			if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+				if gz, err := gzip.NewReader(buffered); err == nil {
+					body = gz
+				}
+			}
+		case "deflate":
+			// Servers send both zlib wrapped and raw deflate streams
+			if len(magic) == 2 && magic[0]&0x0f == 8 && (uint16(magic[0])<<8|uint16(magic[1]))%31 == 0 {
+				if zr, err := zlib.NewReader(buffered); err == nil {
+					body = zr
+				}
+			} else if len(magic) > 0 {
+				body = flate.NewReader(buffered)
+			}
+		case "br":
+			if len(magic) > 0 {
+				body = brotli.NewReader(buffered)
+			}
+		}
+	}
+	return body
+}
+
+// Parses the value of a Refresh header or of a <meta http-equiv="refresh"> following the HTML
+// standard, e.g. "5; url=http://example.com/". It returns the delay in seconds and the target,
+// which is empty when the page just reloads itself. ok is false if the value is not valid.
+func parseRefresh(value string) (delay string, target string, ok bool) {
+	value = strings.TrimLeft(value, " \t\n\f\r")
+
+	end := 0
+	for end < len(value) && value[end] >= '0' && value[end] <= '9' {
+		end++
+	}
+	delay = value[:end]
+	if len(delay) == 0 {
+		if len(value) == 0 || value[0] != '.' {
+			return "", "", false
+		}
+		delay = "0"
+	}
+
+	// Fractional part, ignored
+	for end < len(value) && (value[end] == '.' || (value[end] >= '0' && value[end] <= '9')) {
+		end++
+	}
+
+	rest := strings.TrimLeft(value[end:], " \t\n\f\r")
+	if len(rest) > 0 && (rest[0] == ';' || rest[0] == ',') {
+		rest = strings.TrimLeft(rest[1:], " \t\n\f\r")
+	} else if len(rest) > 0 && end == len(value)-len(rest) {
+		// The delay must be followed by a separator
+		return "", "", false
+	}
+
+	if len(rest) >= 3 && strings.EqualFold(rest[:3], "url") {
+		afterUrl := strings.TrimLeft(rest[3:], " \t\n\f\r")
+		if len(afterUrl) > 0 && afterUrl[0] == '=' {
+			rest = strings.TrimLeft(afterUrl[1:], " \t\n\f\r")
+		}
+	}
+
+	if len(rest) > 0 && (rest[0] == '"' || rest[0] == '\'') {
+		quote := rest[0]
+		rest = rest[1:]
+		if closing := strings.IndexByte(rest, quote); closing >= 0 {
+			rest = rest[:closing]
+		}
+	}
+
+	return delay, strings.TrimSpace(rest), true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/andybalholm/brotli"
+	"io/ioutil"
+	"net/http/httputil"
+	"strings"
+	"testing"
+)
+
+func readBody(t *testing.T, response *HttpResponse) string {
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading the body: %s", err)
+	}
+	return string(body)
+}
+
+func TestReadHttpResponseHeader(t *testing.T) {
+	payload := "HTTP/1.1 301 Moved Permanently\r\n" +
+		"location: http://example.com/new\r\n" +
+		"X-Folded: first\r\n second\r\n" +
+		"this is not a header\r\n" +
+		"CONTENT-TYPE: text/html\r\n" +
+		"\r\n" +
+		"moved"
+
+	response, err := ReadHttpResponse(strings.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != "301" {
+		t.Errorf("unexpected status %q", response.StatusCode)
+	}
+	if response.Header.Get("Location") != "http://example.com/new" {
+		t.Errorf("unexpected location %q", response.Header.Get("Location"))
+	}
+	if response.Header.Get("x-folded") != "first second" {
+		t.Errorf("unexpected folded header %q", response.Header.Get("x-folded"))
+	}
+	if !isHtml(response.Header.Get("Content-Type")) {
+		t.Errorf("unexpected content type %q", response.Header.Get("Content-Type"))
+	}
+	if body := readBody(t, response); body != "moved" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	if _, err := ReadHttpResponse(strings.NewReader("<html>")); err == nil {
+		t.Errorf("expected an error for a payload without status line")
+	}
+}
+
+func TestReadHttpResponseEncodings(t *testing.T) {
+	html := "<html><a href=\"/\">home</a></html>"
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(html))
+	gz.Close()
+
+	var chunked bytes.Buffer
+	chunkedWriter := httputil.NewChunkedWriter(&chunked)
+	chunkedWriter.Write(gzipped.Bytes()[:10])
+	chunkedWriter.Write(gzipped.Bytes()[10:])
+	chunkedWriter.Close()
+	chunked.WriteString("\r\n")
+
+	var zlibbed bytes.Buffer
+	zw := zlib.NewWriter(&zlibbed)
+	zw.Write([]byte(html))
+	zw.Close()
+
+	var brotlied bytes.Buffer
+	bw := brotli.NewWriter(&brotlied)
+	bw.Write([]byte(html))
+	bw.Close()
+
+	cases := map[string]string{
+		"Transfer-Encoding: chunked\r\nContent-Encoding: gzip": chunked.String(),
+		"Content-Encoding: gzip":                               gzipped.String(),
+		"Content-Encoding: deflate":                            zlibbed.String(),
+		"Content-Encoding: br":                                 brotlied.String(),
+		// Payloads stored already decoded are left untouched
+		"Transfer-Encoding: chunked\r\nContent-Encoding: x-gzip": html,
+	}
+
+	for header, body := range cases {
+		payload := "HTTP/1.1 200 OK\r\n" + header + "\r\n\r\n" + body
+		response, err := ReadHttpResponse(strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded := readBody(t, response); decoded != html {
+			t.Errorf("%s: unexpected body %q", header, decoded)
+		}
+	}
+}
+
+func TestParseRefresh(t *testing.T) {
+	cases := []struct {
+		value, delay, target string
+		ok                   bool
+	}{
+		{"0; url=http://example.com/", "0", "http://example.com/", true},
+		{"5;URL='/next page.html'", "5", "/next page.html", true},
+		{" 3 , url = \"other.html\" ", "3", "other.html", true},
+		{"1.5; /next", "1", "/next", true},
+		{"10", "10", "", true},
+		{"0;url=", "0", "", true},
+		{"url=/next", "", "", false},
+		{"5url=/next", "", "", false},
+	}
+
+	for _, c := range cases {
+		delay, target, ok := parseRefresh(c.value)
+		if delay != c.delay || target != c.target || ok != c.ok {
+			t.Errorf("%q: got %q %q %v, expected %q %q %v", c.value, delay, target, ok, c.delay, c.target, c.ok)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+)
+
+// Input path meaning the standard input
+const STDIN_PATH = "-"
+
+// Name of the WARC at the path in the logs and outputs, the last element of the path
+// or of the URL path, "stdin" for the standard input
+func inputName(inputPath string) string {
+	if inputPath == STDIN_PATH {
+		return "stdin"
+	}
+	if isHttpUrl(inputPath) {
+		if inputUrl, err := url.Parse(inputPath); err == nil {
+			return path.Base(inputUrl.Path)
+		}
+	}
+	return path.Base(inputPath)
+}
+
+// Opens the WARC at the path, which is fetched if it is an HTTP(S) or S3 URL, or prefixed with
+// the URL prefix of the options. The standard input is used for STDIN_PATH, it is left open on Close.
+func openInput(inputPath string, httpOptions HttpOptions) (io.ReadCloser, error) {
+	inputPath = resolveInput(inputPath, httpOptions.UrlPrefix)
+	if inputPath == STDIN_PATH {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	if isHttpUrl(inputPath) {
+		return openHttpInput(inputPath, httpOptions, nil)
+	}
+	if isS3Url(inputPath) {
+		return openS3Input(inputPath, httpOptions)
+	}
+	return os.Open(inputPath)
+}
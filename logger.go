@@ -5,7 +5,6 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"os"
-	"sync"
 )
 
 type Exception struct {
@@ -23,12 +22,14 @@ type Logger struct {
 	ErrorsFilePath   string
 	ErrorsFileName   string
 
+	ErrorsFile *os.File
 	ErrorsGZipFileWriter *gzip.Writer
 	ErrorsFileWriter *bufio.Writer
 
 	Exceptions chan Exception
 
-	writingMutex sync.Mutex
+	// Signals that run() consumed all the exceptions
+	done chan bool
 }
 
 func NewLogger(warcPath string, errorsPath string, errorsFileName string) (Logger, error) {
@@ -39,30 +40,31 @@ func NewLogger(warcPath string, errorsPath string, errorsFileName string) (Logge
 		return logger, err
 	}
 
+	logger.ErrorsFile = logFile
 	logger.ErrorsGZipFileWriter = gzip.NewWriter(logFile)
 	logger.ErrorsFileWriter = bufio.NewWriter(logger.ErrorsGZipFileWriter)
 	logger.Exceptions = make(chan Exception, 100)
+	logger.done = make(chan bool)
 	return logger, nil
 }
 
+// Stops the logger once all the pending exceptions are written and finalizes the file.
+// No exception can be sent after calling quit.
 func (logger Logger) quit() {
-	logger.writingMutex.Lock()
+	close(logger.Exceptions)
+	<-logger.done
 	logger.ErrorsFileWriter.Flush()
 	logger.ErrorsGZipFileWriter.Close()
-	logger.writingMutex.Unlock()
+	logger.ErrorsFile.Close()
 }
 
 func (logger Logger) run() {
 
-	for {
-		select {
-		case e := <-logger.Exceptions:
-			logger.writingMutex.Lock()
-			//e.File = logger.WarcPath
-			jsonError, _ := json.Marshal(e)
-			logger.ErrorsFileWriter.Write(jsonError)
-			logger.ErrorsFileWriter.Write([]byte("\n"))
-			logger.writingMutex.Unlock()
-		}
+	for e := range logger.Exceptions {
+		//e.File = logger.WarcPath
+		jsonError, _ := json.Marshal(e)
+		logger.ErrorsFileWriter.Write(jsonError)
+		logger.ErrorsFileWriter.Write([]byte("\n"))
 	}
+	logger.done <- true
 }
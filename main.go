@@ -7,7 +7,9 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"runtime"
 	"runtime/trace"
+	"strings"
 	"time"
 )
 
@@ -20,13 +22,88 @@ func main() {
 
 	enableDebug := flag.Bool("debug", false, "Enable HTTP profile (port 6060) and trace")
 	errorsPath := flag.String("errorsPath", "./errors/", "Path to store the error logs")
+	batchMode := flag.Bool("batch", false, "Read the list of WARC paths (optionally gzipped) from <input_warc> and write one output file per WARC in <output_parquet>")
+	workersCount := flag.Int("workersCount", runtime.NumCPU(), "Number of WARC files processed in parallel in batch mode")
+	maxRecordErrors := flag.Int("maxRecordErrors", 0, "Number of malformed WARC records skipped before giving up on a file")
+	parsersCount := flag.Int("parsersCount", runtime.NumCPU(), "Number of goroutines parsing the HTML pages of each WARC file")
+	unordered := flag.Bool("unordered", false, "Write the markers as soon as the pages are parsed, not in the order of the WARC records")
+	memoryBudget := flag.Int("memoryBudget", DEFAULT_MEMORY_BUDGET/MB, "Approximate memory in MB for the records and markers queued before writing, shared by the files processed in parallel")
+	copyRevisitLinks := flag.Bool("copyRevisitLinks", false, "Copy the links of the original capture to the revisit records referring to it in the same WARC")
+	resolveRedirects := flag.Bool("resolveRedirects", false, "Resolve the redirect chains captured in the same day in the Sequencer outputs <input_parquet>..., files, manifests or directories of partitions, and write them in <output_parquet>")
+	maxHops := flag.Int("maxHops", 10, "Maximum length of a redirect chain with -resolveRedirects")
+	format := flag.String("format", FORMAT_PARQUET, "Format of the output: parquet, jsonl, csv, tsv or arrow (IPC stream)")
+	compression := flag.String("compression", COMPRESSION_NONE, "Compression of the jsonl, csv and tsv outputs: none, gzip or zstd")
+	parquetCodec := flag.String("parquetCodec", DEFAULT_PARQUET_CODEC, "Compression of the Parquet output: uncompressed, snappy, gzip, lz4 or zstd")
+	rowGroupSize := flag.Int("rowGroupSize", DEFAULT_PARQUET_ROW_GROUP_SIZE/MB, "Size in MB of the row groups of the Parquet output")
+	pageSize := flag.Int("pageSize", DEFAULT_PARQUET_PAGE_SIZE/MB, "Size in MB of the pages of the Parquet output, at most the row group size")
+	writerParallelism := flag.Int("writerParallelism", 1, "Number of goroutines encoding the Parquet output of each WARC file")
+	maxPartRows := flag.Int64("maxPartRows", 0, "Split the Parquet output in parts (out-00000.parquet...) of at most N rows, listed in out.manifest.json")
+	maxPartSize := flag.Int("maxPartSize", 0, "Split the Parquet output in parts (out-00000.parquet...) of about N MB once compressed, listed in out.manifest.json")
+	partitioned := flag.Bool("partitioned", false, "Write the Parquet output in the Hive partitions data_origin=/year=/month=/tld= under <output_parquet>")
+	maxOpenPartitions := flag.Int("maxOpenPartitions", DEFAULT_MAX_OPEN_PARTITIONS, "Number of Parquet files kept open by each WARC file with -partitioned, a partition reopened gets a new file")
+	urlPrefix := flag.String("urlPrefix", "", "Prefix of the input WARC paths that are not http(s):// URLs, e.g. https://data.commoncrawl.org/ for crawl-relative paths")
+	httpRetries := flag.Int("httpRetries", DEFAULT_HTTP_RETRIES, "Number of retries, with exponential back-off, of the failed requests and dropped connections of the HTTP(S) inputs and of the S3 inputs and outputs")
 
 
 	flag.Parse()
 
+	if *resolveRedirects {
+		if len(flag.Args()) < 2 {
+			fmt.Fprintln(os.Stderr, "Missing parameters...", flag.Args())
+			fmt.Fprintln(os.Stderr, "Format: ./Sequencer -resolveRedirects [-maxHops 10] <output_parquet> <input_parquet>...")
+			os.Exit(-1)
+		}
+
+		start := time.Now()
+		outputParquet := flag.Args()[0]
+
+		err := os.MkdirAll(path.Dir(outputParquet), os.ModePerm)
+		if err != nil {
+			log.Fatalf("Unable to create the output directory: %s", err)
+		}
+
+		rows, err := ResolveRedirects(flag.Args()[1:], outputParquet, *maxHops)
+		if err != nil {
+			log.Fatalf("Redirects resolution failed: %s", err)
+		}
+		fmt.Fprintln(os.Stderr, "Redirect chains written:", rows)
+		fmt.Fprintln(os.Stderr, "Job completed in:", time.Now().Sub(start))
+		return
+	}
+
 	if len(flag.Args()) < 3 {
-		fmt.Println("Missing parameters...", flag.Args())
-		fmt.Println("Format: ./Sequencer [-debug] [-errorsPath ./errors/] <input_warc> <output_parquet> <data_origin_name>")
+		fmt.Fprintln(os.Stderr, "Missing parameters...", flag.Args())
+		fmt.Fprintln(os.Stderr, "Format: ./Sequencer [-debug] [-errorsPath ./errors/] [-maxRecordErrors N] [-parsersCount N] [-unordered] [-memoryBudget MB] [-copyRevisitLinks] [-format parquet] [-compression none] [-parquetCodec gzip] [-rowGroupSize MB] [-pageSize MB] [-writerParallelism N] [-maxPartRows N] [-maxPartSize MB] [-partitioned] [-maxOpenPartitions N] [-urlPrefix URL] [-httpRetries N] <input_warc|url|-> <output_parquet|s3_url|-> <data_origin_name>")
+		fmt.Fprintln(os.Stderr, "        ./Sequencer -batch [-workersCount N] [-debug] [-errorsPath ./errors/] [-maxRecordErrors N] [-parsersCount N] [-unordered] [-memoryBudget MB] [-copyRevisitLinks] [-format parquet] [-compression none] [-parquetCodec gzip] [-rowGroupSize MB] [-pageSize MB] [-writerParallelism N] [-maxPartRows N] [-maxPartSize MB] [-partitioned] [-maxOpenPartitions N] [-urlPrefix URL] [-httpRetries N] <paths_list> <output_path|s3_url> <data_origin_name>")
+		os.Exit(-1)
+	}
+
+	if *memoryBudget < 1 {
+		fmt.Fprintln(os.Stderr, "The memory budget must be at least 1 MB")
+		os.Exit(-1)
+	}
+	if *httpRetries < 0 {
+		fmt.Fprintln(os.Stderr, "The HTTP retries cannot be negative")
+		os.Exit(-1)
+	}
+	httpOptions := DefaultHttpOptions()
+	httpOptions.UrlPrefix = *urlPrefix
+	httpOptions.Retries = *httpRetries
+
+	parquetOptions, err := NewParquetOptions(*parquetCodec, *rowGroupSize, *pageSize, *writerParallelism)
+	if err == nil {
+		parquetOptions.MaxPartRows = *maxPartRows
+		parquetOptions.MaxPartBytes = int64(*maxPartSize) * MB
+		parquetOptions.Partitioned = *partitioned
+		parquetOptions.MaxOpenPartitions = *maxOpenPartitions
+		err = parquetOptions.validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid Parquet options:", err)
+		os.Exit(-1)
+	}
+	if err := validateSinkFormat(*format, *compression, parquetOptions); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid output:", err)
 		os.Exit(-1)
 	}
 
@@ -34,13 +111,78 @@ func main() {
 	outputParquet := flag.Args()[1]
 	dataOrigin := flag.Args()[2]
 
-	fmt.Println("inputFile =", inputWarcFile)
-	//fmt.Println("urlPrefix =", *urlPrefix)
-	fmt.Println("outputParquet =", outputParquet)
-	//fmt.Println("workersCount =", *workersCount)
-	fmt.Println("dataOrigin =", dataOrigin)
+	if inputWarcFile == STDIN_PATH && *batchMode {
+		fmt.Fprintln(os.Stderr, "The batch mode cannot read the list of WARCs from the standard input")
+		os.Exit(-1)
+	}
+	if outputParquet == STDOUT_PATH {
+		if *batchMode {
+			fmt.Fprintln(os.Stderr, "The batch mode cannot write to the standard output")
+			os.Exit(-1)
+		}
+		if !isLineFormat(*format) {
+			fmt.Fprintln(os.Stderr, "The standard output requires the jsonl, csv or tsv format")
+			os.Exit(-1)
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "inputFile =", inputWarcFile)
+	if len(*urlPrefix) > 0 {
+		fmt.Fprintln(os.Stderr, "urlPrefix =", *urlPrefix)
+	}
+	fmt.Fprintln(os.Stderr, "httpRetries =", *httpRetries)
+	fmt.Fprintln(os.Stderr, "outputParquet =", outputParquet)
+	if *batchMode {
+		fmt.Fprintln(os.Stderr, "workersCount =", *workersCount)
+	}
+	fmt.Fprintln(os.Stderr, "dataOrigin =", dataOrigin)
+
+	fmt.Fprintln(os.Stderr, "errorsPath =", *errorsPath)
+	fmt.Fprintln(os.Stderr, "maxRecordErrors =", *maxRecordErrors)
+	fmt.Fprintln(os.Stderr, "parsersCount =", *parsersCount)
+	fmt.Fprintln(os.Stderr, "unordered =", *unordered)
+	fmt.Fprintln(os.Stderr, "memoryBudget =", *memoryBudget, "MB")
+	fmt.Fprintln(os.Stderr, "copyRevisitLinks =", *copyRevisitLinks)
+	fmt.Fprintln(os.Stderr, "format =", *format)
+	if *format == FORMAT_PARQUET {
+		fmt.Fprintln(os.Stderr, "parquetCodec =", parquetOptions.Codec)
+		fmt.Fprintln(os.Stderr, "rowGroupSize =", *rowGroupSize, "MB")
+		fmt.Fprintln(os.Stderr, "pageSize =", *pageSize, "MB")
+		fmt.Fprintln(os.Stderr, "writerParallelism =", *writerParallelism)
+	} else {
+		fmt.Fprintln(os.Stderr, "compression =", *compression)
+	}
+	if parquetOptions.rolling() {
+		fmt.Fprintln(os.Stderr, "maxPartRows =", *maxPartRows)
+		fmt.Fprintln(os.Stderr, "maxPartSize =", *maxPartSize, "MB")
+	}
+	if *partitioned {
+		fmt.Fprintln(os.Stderr, "partitioned =", *partitioned)
+		fmt.Fprintln(os.Stderr, "maxOpenPartitions =", *maxOpenPartitions)
+	}
+
+	config := ExtractionConfig{
+		MaxRecordErrors:  *maxRecordErrors,
+		CopyRevisitLinks: *copyRevisitLinks,
+		ParsersCount:     *parsersCount,
+		Unordered:        *unordered,
+		MemoryBudget:     NewMemoryBudget(int64(*memoryBudget) * MB),
+		Format:           *format,
+		Compression:      *compression,
+		Parquet:          &parquetOptions,
+		Http:             &httpOptions,
+	}
 
-	fmt.Println("errorsPath =", *errorsPath)
+	// Flushes the trace, os.Exit skips the deferred calls
+	stopDebug := func() {}
+	exit := func(code int) {
+		stopDebug()
+		os.Exit(code)
+	}
+	fatalf := func(format string, v ...interface{}) {
+		log.Printf(format, v...)
+		exit(1)
+	}
 
 	if *enableDebug {
 		go func() {
@@ -51,35 +193,76 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		defer f.Close()
 
 		err = trace.Start(f)
 		if err != nil {
 			panic(err)
 		}
-		defer trace.Stop()
-		fmt.Println("Debug tools started")
+		stopDebug = func() {
+			trace.Stop()
+			f.Close()
+		}
+		defer stopDebug()
+		fmt.Fprintln(os.Stderr, "Debug tools started")
 	}
 
-	//lines, err := readLines(inputFile)
-	//if err != nil {
-	//	log.Fatalf("readLines: %s", err)
-	//}
+	if *batchMode {
+		lines, err := readLines(inputWarcFile)
+		if err != nil {
+			fatalf("readLines: %s", err)
+		}
 
-	inputFileName := path.Base(inputWarcFile)
+		err = makeDestinationDir(outputParquet)
+		if err != nil {
+			fatalf("Unable to create the output directory: %s", err)
+		}
 
-	// Create output path
-	err := os.MkdirAll(path.Dir(outputParquet), os.ModePerm)
-	if err != nil {
-		log.Fatalf("Unable to create the output directory: %s", err)
-		panic(err)
+		err = os.MkdirAll(path.Dir(*errorsPath), os.ModePerm)
+		if err != nil {
+			fatalf("Unable to create the errors directory: %s", err)
+		}
+
+		start := time.Now()
+
+		var paths []string
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			if len(line) > 0 {
+				paths = append(paths, line)
+			}
+		}
+
+		results, err := RunBatch(paths, outputParquet, dataOrigin, *errorsPath, *workersCount, config)
+		if err != nil {
+			fatalf("Invalid batch: %s", err)
+		}
+		failed := printBatchSummary(results)
+
+		fmt.Fprintln(os.Stderr, "Job completed in:", time.Now().Sub(start))
+		if failed > 0 {
+			exit(1)
+		}
+		return
+	}
+
+	inputFileName := inputName(inputWarcFile)
+
+	// Create output path, the root of the partitions if the output is partitioned
+	outputDir := path.Dir(outputParquet)
+	if *partitioned {
+		outputDir = outputParquet
+	}
+	if outputParquet != STDOUT_PATH && !isS3Url(outputParquet) {
+		err = os.MkdirAll(outputDir, os.ModePerm)
+		if err != nil {
+			fatalf("Unable to create the output directory: %s", err)
+		}
 	}
 
 	// Create errors path
 	err = os.MkdirAll(path.Dir(*errorsPath), os.ModePerm)
 	if err != nil {
-		log.Fatalf("Unable to create the errors directory: %s", err)
-		panic(err)
+		fatalf("Unable to create the errors directory: %s", err)
 	}
 
 	start := time.Now()
@@ -87,32 +270,21 @@ func main() {
 
 	logger, err := NewLogger(inputWarcFile, *errorsPath, inputFileName)
 	if err != nil {
-		fmt.Println("Error in creating the log file...")
+		fmt.Fprintln(os.Stderr, "Error in creating the log file...")
 		panic(err)
 	}
 	go logger.run()
 
-	LinkExtractionWorker(inputWarcFile, outputParquet, dataOrigin, logger)
-
-	//for w := 1; w <= *workersCount; w++ {
-	//	//fmt.Println(dataOrigin, pathsChannel, &workersWaitGroup, logger)
-	//	workersWaitGroup.Add(1)
-	//	go LinkExtractionWorker(dataOrigin, pathsChannel, &workersWaitGroup, logger)
-	//}
-
-	//for _, line := range lines {
-	//	sourceWarc := *urlPrefix + line
-	//	//fmt.Println(sourceWarc)
-	//	file := filepath.Base(sourceWarc)
-	//	pathsChannel <- SourceDestination{SourceFile: sourceWarc, DestinationFile: outputPath + "/" + file + ".parquet"}
-	//}
-	//
-	//close(pathsChannel)
+	stats, err := LinkExtractionWorker(inputWarcFile, outputParquet, dataOrigin, config, logger)
 
-
-	//workersWaitGroup.Wait()
 	logger.quit()
 
-	fmt.Println("Job completed in:", time.Now().Sub(start))
+	fmt.Fprintln(os.Stderr, "Output written:", stats)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Job failed after", time.Now().Sub(start), "-", err)
+		exit(1)
+	}
+
+	fmt.Fprintln(os.Stderr, "Job completed in:", time.Now().Sub(start))
 
 }
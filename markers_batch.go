@@ -0,0 +1,242 @@
+package main
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// Bytes of a row of a MarkerBatch besides its strings
+var markerRowSize = int64(unsafe.Sizeof(Marker{}))
+
+// Markers stored by column. Source, SourceHost and DataOrigin, which repeat for all the links
+// of a page and all the pages of a WARC, are interned so that each distinct value is kept once.
+// The batches are reused through a pool: get them with NewMarkerBatch and give them back with
+// Release once their markers have been copied or written.
+type MarkerBatch struct {
+	Date              []int64
+	SourceHost        []string
+	Secure            []bool
+	Source            []string
+	Link              []string
+	Fragment          []string
+	Tag               []string
+	Extras            []string
+	DataOrigin        []string
+	BaseOverride      []bool
+	Rel               []string
+	Hreflang          []string
+	Target            []string
+	Title             []string
+	Canonical         []string
+	WarcFile          []string
+	WarcOffset        []int64
+	WarcLength        []int64
+	WarcRecordId      []string
+	WarcPayloadDigest []string
+	Revisit           []bool
+	RefersToUri       []string
+	RefersToDate      []int64
+	Referrer          []string
+	Via               []string
+	HopsFromSeed      []string
+
+	// Interned strings of the batch
+	interned map[string]string
+	// Estimated bytes of the markers, the interned strings are counted once
+	size int64
+}
+
+var markerBatchPool = sync.Pool{
+	New: func() interface{} {
+		return &MarkerBatch{interned: make(map[string]string)}
+	},
+}
+
+// Gets an empty batch from the pool
+func NewMarkerBatch() *MarkerBatch {
+	return markerBatchPool.Get().(*MarkerBatch)
+}
+
+// Empties the batch and gives it back to the pool, it must not be used anymore
+func (b *MarkerBatch) Release() {
+	b.reset()
+	markerBatchPool.Put(b)
+}
+
+// Number of markers in the batch
+func (b *MarkerBatch) Len() int {
+	return len(b.Date)
+}
+
+// Returns the interned copy of a value of the column, the consecutive markers
+// of a page share it so the last value of the column is checked first
+func (b *MarkerBatch) intern(column []string, value string) string {
+	if last := len(column) - 1; last >= 0 && column[last] == value {
+		return column[last]
+	}
+	if interned, found := b.interned[value]; found {
+		return interned
+	}
+	b.interned[value] = value
+	b.size += int64(len(value))
+	return value
+}
+
+// Appends a copy of the marker
+func (b *MarkerBatch) Append(m *Marker) {
+	b.Date = append(b.Date, m.Date)
+	b.SourceHost = append(b.SourceHost, b.intern(b.SourceHost, m.SourceHost))
+	b.Secure = append(b.Secure, m.Secure)
+	b.Source = append(b.Source, b.intern(b.Source, m.Source))
+	b.Link = append(b.Link, m.Link)
+	b.Fragment = append(b.Fragment, m.Fragment)
+	b.Tag = append(b.Tag, m.Tag)
+	b.Extras = append(b.Extras, m.Extras)
+	b.DataOrigin = append(b.DataOrigin, b.intern(b.DataOrigin, m.DataOrigin))
+	b.BaseOverride = append(b.BaseOverride, m.BaseOverride)
+	b.Rel = append(b.Rel, m.Rel)
+	b.Hreflang = append(b.Hreflang, m.Hreflang)
+	b.Target = append(b.Target, m.Target)
+	b.Title = append(b.Title, m.Title)
+	b.Canonical = append(b.Canonical, m.Canonical)
+	b.WarcFile = append(b.WarcFile, m.WarcFile)
+	b.WarcOffset = append(b.WarcOffset, m.WarcOffset)
+	b.WarcLength = append(b.WarcLength, m.WarcLength)
+	b.WarcRecordId = append(b.WarcRecordId, m.WarcRecordId)
+	b.WarcPayloadDigest = append(b.WarcPayloadDigest, m.WarcPayloadDigest)
+	b.Revisit = append(b.Revisit, m.Revisit)
+	b.RefersToUri = append(b.RefersToUri, m.RefersToUri)
+	b.RefersToDate = append(b.RefersToDate, m.RefersToDate)
+	b.Referrer = append(b.Referrer, m.Referrer)
+	b.Via = append(b.Via, m.Via)
+	b.HopsFromSeed = append(b.HopsFromSeed, m.HopsFromSeed)
+
+	b.size += markerRowSize
+	b.size += int64(len(m.Link) +
+		len(m.Fragment) +
+		len(m.Tag) +
+		len(m.Extras) +
+		len(m.Rel) +
+		len(m.Hreflang) +
+		len(m.Target) +
+		len(m.Title) +
+		len(m.Canonical) +
+		len(m.WarcFile) +
+		len(m.WarcRecordId) +
+		len(m.WarcPayloadDigest) +
+		len(m.RefersToUri) +
+		len(m.Referrer) +
+		len(m.Via) +
+		len(m.HopsFromSeed))
+}
+
+// Appends the markers of another batch
+func (b *MarkerBatch) AppendBatch(other *MarkerBatch) {
+	for i := 0; i < other.Len(); i++ {
+		b.SourceHost = append(b.SourceHost, b.intern(b.SourceHost, other.SourceHost[i]))
+		b.Source = append(b.Source, b.intern(b.Source, other.Source[i]))
+		b.DataOrigin = append(b.DataOrigin, b.intern(b.DataOrigin, other.DataOrigin[i]))
+	}
+	b.Date = append(b.Date, other.Date...)
+	b.Secure = append(b.Secure, other.Secure...)
+	b.Link = append(b.Link, other.Link...)
+	b.Fragment = append(b.Fragment, other.Fragment...)
+	b.Tag = append(b.Tag, other.Tag...)
+	b.Extras = append(b.Extras, other.Extras...)
+	b.BaseOverride = append(b.BaseOverride, other.BaseOverride...)
+	b.Rel = append(b.Rel, other.Rel...)
+	b.Hreflang = append(b.Hreflang, other.Hreflang...)
+	b.Target = append(b.Target, other.Target...)
+	b.Title = append(b.Title, other.Title...)
+	b.Canonical = append(b.Canonical, other.Canonical...)
+	b.WarcFile = append(b.WarcFile, other.WarcFile...)
+	b.WarcOffset = append(b.WarcOffset, other.WarcOffset...)
+	b.WarcLength = append(b.WarcLength, other.WarcLength...)
+	b.WarcRecordId = append(b.WarcRecordId, other.WarcRecordId...)
+	b.WarcPayloadDigest = append(b.WarcPayloadDigest, other.WarcPayloadDigest...)
+	b.Revisit = append(b.Revisit, other.Revisit...)
+	b.RefersToUri = append(b.RefersToUri, other.RefersToUri...)
+	b.RefersToDate = append(b.RefersToDate, other.RefersToDate...)
+	b.Referrer = append(b.Referrer, other.Referrer...)
+	b.Via = append(b.Via, other.Via...)
+	b.HopsFromSeed = append(b.HopsFromSeed, other.HopsFromSeed...)
+
+	// The interned strings of the other batch have just been counted by intern
+	b.size += other.size
+	for value := range other.interned {
+		b.size -= int64(len(value))
+	}
+}
+
+// Sets the WARC file and the offset of the record the markers were extracted from
+func (b *MarkerBatch) setWarcRecord(warcFile string, warcOffset int64) {
+	for i := range b.WarcFile {
+		b.size += int64(len(warcFile) - len(b.WarcFile[i]))
+		b.WarcFile[i] = warcFile
+		b.WarcOffset[i] = warcOffset
+	}
+}
+
+// Copies the i-th marker of the batch in m
+func (b *MarkerBatch) Row(i int, m *Marker) {
+	m.Date = b.Date[i]
+	m.SourceHost = b.SourceHost[i]
+	m.Secure = b.Secure[i]
+	m.Source = b.Source[i]
+	m.Link = b.Link[i]
+	m.Fragment = b.Fragment[i]
+	m.Tag = b.Tag[i]
+	m.Extras = b.Extras[i]
+	m.DataOrigin = b.DataOrigin[i]
+	m.BaseOverride = b.BaseOverride[i]
+	m.Rel = b.Rel[i]
+	m.Hreflang = b.Hreflang[i]
+	m.Target = b.Target[i]
+	m.Title = b.Title[i]
+	m.Canonical = b.Canonical[i]
+	m.WarcFile = b.WarcFile[i]
+	m.WarcOffset = b.WarcOffset[i]
+	m.WarcLength = b.WarcLength[i]
+	m.WarcRecordId = b.WarcRecordId[i]
+	m.WarcPayloadDigest = b.WarcPayloadDigest[i]
+	m.Revisit = b.Revisit[i]
+	m.RefersToUri = b.RefersToUri[i]
+	m.RefersToDate = b.RefersToDate[i]
+	m.Referrer = b.Referrer[i]
+	m.Via = b.Via[i]
+	m.HopsFromSeed = b.HopsFromSeed[i]
+}
+
+// Empties the batch keeping the capacity of the columns
+func (b *MarkerBatch) reset() {
+	b.Date = b.Date[:0]
+	b.SourceHost = b.SourceHost[:0]
+	b.Secure = b.Secure[:0]
+	b.Source = b.Source[:0]
+	b.Link = b.Link[:0]
+	b.Fragment = b.Fragment[:0]
+	b.Tag = b.Tag[:0]
+	b.Extras = b.Extras[:0]
+	b.DataOrigin = b.DataOrigin[:0]
+	b.BaseOverride = b.BaseOverride[:0]
+	b.Rel = b.Rel[:0]
+	b.Hreflang = b.Hreflang[:0]
+	b.Target = b.Target[:0]
+	b.Title = b.Title[:0]
+	b.Canonical = b.Canonical[:0]
+	b.WarcFile = b.WarcFile[:0]
+	b.WarcOffset = b.WarcOffset[:0]
+	b.WarcLength = b.WarcLength[:0]
+	b.WarcRecordId = b.WarcRecordId[:0]
+	b.WarcPayloadDigest = b.WarcPayloadDigest[:0]
+	b.Revisit = b.Revisit[:0]
+	b.RefersToUri = b.RefersToUri[:0]
+	b.RefersToDate = b.RefersToDate[:0]
+	b.Referrer = b.Referrer[:0]
+	b.Via = b.Via[:0]
+	b.HopsFromSeed = b.HopsFromSeed[:0]
+	for value := range b.interned {
+		delete(b.interned, value)
+	}
+	b.size = 0
+}
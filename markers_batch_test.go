@@ -0,0 +1,244 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Copies the markers of the batch
+func batchMarkers(batch *MarkerBatch) []Marker {
+	markers := make([]Marker, batch.Len())
+	for i := range markers {
+		batch.Row(i, &markers[i])
+	}
+	return markers
+}
+
+// Links of a page as getLinks extracts them
+func testPageLinks(page int, count int) []Marker {
+	source := "http://example.com/page" + strconv.Itoa(page)
+	links := make([]Marker, count)
+	for i := range links {
+		links[i] = NewMarker(1600000000, "com.example", false, source,
+			"http://example.com/link"+strconv.Itoa(i), "", "a", "Link text", "test")
+		links[i].WarcFile = "test.warc.gz"
+	}
+	return links
+}
+
+func TestMarkerBatch(t *testing.T) {
+	batch := NewMarkerBatch()
+	defer batch.Release()
+
+	links := testPageLinks(0, 3)
+	links[1].Rel = "nofollow"
+	links[2].Revisit = true
+	for i := range links {
+		batch.Append(&links[i])
+	}
+
+	other := NewMarkerBatch()
+	more := testPageLinks(1, 2)
+	for i := range more {
+		other.Append(&more[i])
+	}
+	batch.AppendBatch(other)
+	other.Release()
+
+	markers := batchMarkers(batch)
+	expected := append(links, more...)
+	if len(markers) != len(expected) {
+		t.Fatalf("unexpected length %d", len(markers))
+	}
+	for i := range expected {
+		if markers[i] != expected[i] {
+			t.Errorf("marker %d: expected %+v, got %+v", i, expected[i], markers[i])
+		}
+	}
+
+	// The interned strings are counted once
+	var listSize int64
+	for i := range expected {
+		listSize += expected[i].estimatedSize()
+	}
+	repeated := int64(len(expected)-1)*int64(len("com.example")+len("test")) +
+		int64(len(links)-1+len(more)-1)*int64(len(links[0].Source))
+	if batch.size != listSize-repeated {
+		t.Errorf("unexpected size %d, expected %d", batch.size, listSize-repeated)
+	}
+
+	batch.setWarcRecord("other.warc.gz", 42)
+	if batch.WarcFile[4] != "other.warc.gz" || batch.WarcOffset[0] != 42 {
+		t.Errorf("WARC record not set: %v %v", batch.WarcFile, batch.WarcOffset)
+	}
+	if batch.size != listSize-repeated+int64(len(expected)) {
+		t.Errorf("unexpected size %d after setting the WARC record", batch.size)
+	}
+
+	batch.reset()
+	if batch.Len() != 0 || batch.size != 0 || len(batch.interned) != 0 {
+		t.Errorf("batch not reset: %d markers, size %d", batch.Len(), batch.size)
+	}
+}
+
+// Linked list the markers were collated in before MarkerBatch, kept as the baseline of its benchmarks
+type MarkersList struct {
+	head   *ListNode
+	tail   *ListNode
+	length int32
+	// Estimated bytes of the markers, when they were appended
+	size int64
+}
+
+type ListNode struct {
+	next   *ListNode
+	Marker *Marker
+}
+
+// Appends a Marker element to the referred MarkersList
+func (ml *MarkersList) append(link *Marker) {
+	node := ListNode{Marker: link, next: nil}
+	if ml.head == nil {
+		ml.head = &node
+		ml.tail = &node
+	} else {
+		ml.tail.next = &node
+		ml.tail = &node
+	}
+	ml.length++
+	ml.size += link.estimatedSize()
+}
+
+// Appends a MarkersList to the referred MarkersList
+func (ml *MarkersList) appendList(linksList *MarkersList) {
+	if linksList.length > 0 {
+		if ml.head == nil {
+			ml.head = linksList.head
+			ml.tail = linksList.tail
+		} else {
+			ml.tail.next = linksList.head
+			ml.tail = linksList.tail
+		}
+		ml.length += linksList.length
+		ml.size += linksList.size
+	}
+}
+
+// Creates a copy of the referred MarkersList
+func (ml *MarkersList) copy() MarkersList {
+	return MarkersList{head: ml.head, tail: ml.tail, length: ml.length, size: ml.size}
+}
+
+// Markers of the benchmarks: 100 pages with 200 links each
+const (
+	benchmarkPages = 100
+	benchmarkLinks = 200
+)
+
+func BenchmarkMarkersListAppend(b *testing.B) {
+	pages := make([][]Marker, benchmarkPages)
+	for i := range pages {
+		pages[i] = testPageLinks(i, benchmarkLinks)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		chunk := MarkersList{}
+		for _, links := range pages {
+			pageLinks := MarkersList{}
+			for i := range links {
+				// getLinks allocated a new marker for each link
+				link := links[i]
+				pageLinks.append(&link)
+			}
+			chunk.appendList(&pageLinks)
+		}
+		writeMarkersList(&chunk)
+	}
+}
+
+func BenchmarkMarkerBatchAppend(b *testing.B) {
+	pages := make([][]Marker, benchmarkPages)
+	for i := range pages {
+		pages[i] = testPageLinks(i, benchmarkLinks)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		chunk := NewMarkerBatch()
+		for _, links := range pages {
+			pageLinks := NewMarkerBatch()
+			for i := range links {
+				link := links[i]
+				pageLinks.Append(&link)
+			}
+			chunk.AppendBatch(pageLinks)
+			pageLinks.Release()
+		}
+		writeMarkerBatch(chunk)
+		chunk.Release()
+	}
+}
+
+func BenchmarkMarkersListAppendList(b *testing.B) {
+	pages := make([]MarkersList, benchmarkPages)
+	for i := range pages {
+		links := testPageLinks(i, benchmarkLinks)
+		for j := range links {
+			pages[i].append(&links[j])
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		chunk := MarkersList{}
+		for i := range pages {
+			page := pages[i].copy()
+			chunk.appendList(&page)
+		}
+		// Unlinks the pages to reuse them
+		for i := range pages {
+			pages[i].tail.next = nil
+		}
+	}
+}
+
+func BenchmarkMarkerBatchAppendBatch(b *testing.B) {
+	pages := make([]*MarkerBatch, benchmarkPages)
+	for i := range pages {
+		pages[i] = NewMarkerBatch()
+		links := testPageLinks(i, benchmarkLinks)
+		for j := range links {
+			pages[i].Append(&links[j])
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		chunk := NewMarkerBatch()
+		for _, page := range pages {
+			chunk.AppendBatch(page)
+		}
+		chunk.Release()
+	}
+}
+
+// Sink of the rows, the result is kept to avoid the loops being optimized away
+var benchmarkRows []Marker
+
+// Copies the markers as values, like the Parquet writer
+func writeMarkersList(list *MarkersList) {
+	benchmarkRows = benchmarkRows[:0]
+	for node := list.head; node != nil; node = node.next {
+		benchmarkRows = append(benchmarkRows, *node.Marker)
+	}
+}
+
+func writeMarkerBatch(batch *MarkerBatch) {
+	benchmarkRows = benchmarkRows[:0]
+	var marker Marker
+	for i := 0; i < batch.Len(); i++ {
+		batch.Row(i, &marker)
+		benchmarkRows = append(benchmarkRows, marker)
+	}
+}
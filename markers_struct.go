@@ -2,8 +2,13 @@ package main
 
 import (
 	"unicode/utf8"
+	"unsafe"
 )
 
+// Tags of the redirect markers
+const REFRESH_HEADER_TAG = "refresh-header"
+const META_REFRESH_TAG = "meta-refresh"
+
 type Marker struct {
 	Date       int64  `parquet:"name=date, type=INT64"`
 	SourceHost string `parquet:"name=source_host, type=UTF8, encoding=PLAIN_DICTIONARY"`
@@ -14,6 +19,33 @@ type Marker struct {
 	Tag        string `parquet:"name=tag, type=UTF8, encoding=PLAIN_DICTIONARY"`
 	Extras     string `parquet:"name=extras, type=UTF8, encoding=PLAIN_DICTIONARY"`
 	DataOrigin string `parquet:"name=data_origin, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	// The link was resolved against the <base> of the page instead of its URL
+	BaseOverride bool `parquet:"name=base_override, type=BOOLEAN"`
+	// Normalized rel tokens of the link, lower case, sorted and separated by a space
+	Rel      string `parquet:"name=rel, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Hreflang string `parquet:"name=hreflang, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	// Browsing context (e.g. "_blank") and advisory title of the hyperlinks
+	Target string `parquet:"name=target, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Title  string `parquet:"name=title, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	// Canonical URL declared by the page, only in page markers
+	Canonical string `parquet:"name=canonical, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	// WARC file and offset of the record the marker was extracted from
+	WarcFile   string `parquet:"name=warc_file, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	WarcOffset int64  `parquet:"name=warc_offset, type=INT64"`
+	// Provenance of the record, only in page markers. The length is -1 when unknown.
+	WarcLength        int64  `parquet:"name=warc_length, type=INT64"`
+	WarcRecordId      string `parquet:"name=warc_record_id, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	WarcPayloadDigest string `parquet:"name=warc_payload_digest, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	// The page marker comes from a revisit record, the payload was the same of an earlier capture
+	Revisit bool `parquet:"name=revisit, type=BOOLEAN"`
+	// URL and date of the capture the revisit refers to, only in revisit page markers
+	RefersToUri  string `parquet:"name=refers_to_uri, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	RefersToDate int64  `parquet:"name=refers_to_date, type=INT64"`
+	// How the crawler reached the page, from its request and metadata records, only in page markers.
+	// The hop path from the seed uses the Heritrix codes, e.g. "LLR".
+	Referrer     string `parquet:"name=referrer, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Via          string `parquet:"name=via, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	HopsFromSeed string `parquet:"name=hops_from_seed, type=UTF8, encoding=PLAIN_DICTIONARY"`
 }
 
 // Constructs a generic WebGenome Marker
@@ -55,6 +87,34 @@ func NewWebpageMarker(
 	return NewMarker(date, sourceHost, secure, source, "", "", httpCode, extras, dataOrigin)
 }
 
+// Constructs a special marker for a redirect declared with the Refresh header or a meta refresh.
+// The tag tells the origin of the redirect and the extras contain the delay in seconds.
+func NewRedirectMarker(
+	date int64,
+	sourceHost string,
+	secure bool,
+	source string,
+	target string,
+	fragment string,
+	tag string,
+	delay string,
+	dataOrigin string) Marker {
+
+	return NewMarker(date, sourceHost, secure, source, target, fragment, tag, delay, dataOrigin)
+}
+
+// Approximate number of bytes taken in memory by the marker outside of a MarkerBatch.
+// Strings shared between markers are counted for each of them.
+func (m *Marker) estimatedSize() int64 {
+	size := int(unsafe.Sizeof(*m))
+	for _, field := range []string{m.SourceHost, m.Source, m.Link, m.Fragment, m.Tag, m.Extras, m.DataOrigin,
+		m.Rel, m.Hreflang, m.Target, m.Title, m.Canonical, m.WarcFile, m.WarcRecordId, m.WarcPayloadDigest,
+		m.RefersToUri, m.Referrer, m.Via, m.HopsFromSeed} {
+		size += len(field)
+	}
+	return int64(size)
+}
+
 func toValidUTF8(text string) string {
 	if utf8.ValidString(text) {
 		return text
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+const MB = 1024 * 1024
+
+// Budget used when none is configured
+const DEFAULT_MEMORY_BUDGET = 1024 * MB
+
+// The markers are sent to the writer in chunks of this fraction of the budget, divided
+// among the extractions sharing it
+const CHUNKS_PER_BUDGET = 16
+
+// Chunks that can wait in the channel of the writer, the budget limits their size
+const WRITER_QUEUE_LENGTH = 4
+
+// Approximate number of bytes held by the records and markers queued between the reader,
+// the parsers and the writers. Only the reader waits for the budget, the other stages add
+// and release bytes without blocking, so that they can always drain the queues.
+type MemoryBudget struct {
+	limit int64
+	used  int64
+	// Bytes of used released only after more records are read, see hold
+	held int64
+	// Highest number of bytes used
+	peak int64
+	// Extractions sharing the budget, the bytes each one holds are bounded by its share
+	workers int64
+	mutex   sync.Mutex
+	freed   *sync.Cond
+}
+
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	budget := &MemoryBudget{limit: limit, workers: 1}
+	budget.freed = sync.NewCond(&budget.mutex)
+	return budget
+}
+
+// Waits until the bytes fit in the budget and takes them. A single item larger than
+// the budget is let through when nothing else is queued but the bytes held.
+// The chunks being filled and the original captures grow without waiting, the shares
+// of all the extractions are kept for them.
+func (b *MemoryBudget) acquire(bytes int64) {
+	b.mutex.Lock()
+	reserved := b.limit/CHUNKS_PER_BUDGET + b.limit/ORIGINAL_CAPTURES_BUDGET_SHARE
+	for b.used > b.held && b.used+bytes > b.limit-reserved {
+		b.freed.Wait()
+	}
+	b.take(bytes)
+	b.mutex.Unlock()
+}
+
+// Takes the bytes without waiting, even if the budget is exceeded
+func (b *MemoryBudget) add(bytes int64) {
+	b.mutex.Lock()
+	b.take(bytes)
+	b.mutex.Unlock()
+}
+
+func (b *MemoryBudget) take(bytes int64) {
+	b.used += bytes
+	if b.used > b.peak {
+		b.peak = b.used
+	}
+}
+
+// Replaces bytes taken with a new estimate of them, without waiting
+func (b *MemoryBudget) update(previous, bytes int64) {
+	if bytes > previous {
+		b.add(bytes - previous)
+	} else if bytes < previous {
+		b.release(previous - bytes)
+	}
+}
+
+// Marks bytes taken as held: they can only be released after more records are read, like
+// the markers waiting in the join window. Waiting for them would never end, so the reader
+// does not wait when all the bytes used are held.
+func (b *MemoryBudget) hold(bytes int64) {
+	b.mutex.Lock()
+	b.held += bytes
+	b.mutex.Unlock()
+}
+
+// Unmarks bytes held, before releasing them or when they move to a queue that drains by itself
+func (b *MemoryBudget) unhold(bytes int64) {
+	b.mutex.Lock()
+	b.held -= bytes
+	b.mutex.Unlock()
+	b.freed.Broadcast()
+}
+
+// Gives back bytes taken with acquire or add
+func (b *MemoryBudget) release(bytes int64) {
+	b.mutex.Lock()
+	b.used -= bytes
+	b.mutex.Unlock()
+	b.freed.Broadcast()
+}
+
+func (b *MemoryBudget) Used() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.used
+}
+
+// Highest number of bytes used so far
+func (b *MemoryBudget) Peak() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.peak
+}
+
+func (b *MemoryBudget) Limit() int64 {
+	return b.limit
+}
+
+// Splits the budget among the extractions running at the same time, as the workers of a
+// batch. The bytes they hold do not block the readers, so each one holds only its share.
+func (b *MemoryBudget) shareAmong(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	b.mutex.Lock()
+	b.workers = int64(workers)
+	b.mutex.Unlock()
+}
+
+// Bytes of a fraction of the share of the budget of each extraction
+func (b *MemoryBudget) share(fraction int64) int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if size := b.limit / fraction / b.workers; size > 0 {
+		return size
+	}
+	return 1
+}
+
+// Size of the chunks of markers sent to the writer
+func (b *MemoryBudget) chunkSize() int64 {
+	return b.share(CHUNKS_PER_BUDGET)
+}
+
+// Bytes of the heap in use, for the progress output
+func heapInUse() int64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return int64(stats.HeapInuse)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	budget := NewMemoryBudget(100)
+
+	// Larger than the budget, but nothing else is queued
+	budget.acquire(150)
+	budget.release(150)
+
+	budget.acquire(60)
+	budget.add(60)
+	if budget.Used() != 120 {
+		t.Fatalf("expected 120 bytes used, got %d", budget.Used())
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		budget.acquire(20)
+		acquired <- true
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("the budget is exceeded, acquire should wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The shares of the chunks and of the original captures, 18 bytes, are kept
+	budget.release(60)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("acquire should complete after the release")
+	}
+	if budget.Used() != 80 {
+		t.Errorf("expected 80 bytes used, got %d", budget.Used())
+	}
+	if budget.chunkSize() != 100/CHUNKS_PER_BUDGET {
+		t.Errorf("unexpected chunk size %d", budget.chunkSize())
+	}
+
+	// Each extraction sharing the budget fills smaller chunks
+	budget.shareAmong(2)
+	if budget.chunkSize() != 100/CHUNKS_PER_BUDGET/2 || budget.share(ORIGINAL_CAPTURES_BUDGET_SHARE) != 100/ORIGINAL_CAPTURES_BUDGET_SHARE/2 {
+		t.Errorf("unexpected shares %d and %d", budget.chunkSize(), budget.share(ORIGINAL_CAPTURES_BUDGET_SHARE))
+	}
+}
+
+func TestMemoryBudgetHold(t *testing.T) {
+	budget := NewMemoryBudget(100)
+
+	// Only held bytes are used, waiting for them would never end
+	budget.acquire(80)
+	budget.hold(80)
+	budget.acquire(50)
+	if budget.Used() != 130 || budget.Peak() != 130 {
+		t.Fatalf("expected 130 bytes used, got %d", budget.Used())
+	}
+
+	// Bytes not held are used, the budget is exceeded
+	acquired := make(chan bool)
+	go func() {
+		budget.acquire(10)
+		acquired <- true
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("the budget is exceeded, acquire should wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	budget.unhold(80)
+	budget.release(80)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("acquire should complete after the release")
+	}
+
+	budget.update(60, 20)
+	if budget.Used() != 20 || budget.Peak() != 130 {
+		t.Errorf("expected 20 bytes used and a peak of 130, got %d and %d", budget.Used(), budget.Peak())
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+	"strings"
+)
+
+// Defaults of the Parquet writer
+const (
+	DEFAULT_PARQUET_CODEC          = "gzip"
+	DEFAULT_PARQUET_ROW_GROUP_SIZE = 8 * MB
+	DEFAULT_PARQUET_PAGE_SIZE      = 2 * MB
+)
+
+// Compression codecs supported by the Parquet writer
+var parquetCodecs = map[string]parquet.CompressionCodec{
+	"uncompressed": parquet.CompressionCodec_UNCOMPRESSED,
+	"snappy":       parquet.CompressionCodec_SNAPPY,
+	"gzip":         parquet.CompressionCodec_GZIP,
+	"lz4":          parquet.CompressionCodec_LZ4,
+	"zstd":         parquet.CompressionCodec_ZSTD,
+}
+
+// Options of the Parquet files written by the extraction
+type ParquetOptions struct {
+	Codec parquet.CompressionCodec
+	// Bytes of the rows buffered before a row group is written
+	RowGroupSize int64
+	// Bytes of the column pages
+	PageSize int64
+	// Goroutines encoding the pages of a row group
+	Parallelism int64
+	// The output is split in parts of at most these rows and compressed bytes, if they are not 0
+	MaxPartRows  int64
+	MaxPartBytes int64
+	// The output is a directory of Hive partitions, with at most MaxOpenPartitions files open
+	Partitioned       bool
+	MaxOpenPartitions int
+}
+
+func DefaultParquetOptions() ParquetOptions {
+	return ParquetOptions{
+		Codec:        parquetCodecs[DEFAULT_PARQUET_CODEC],
+		RowGroupSize: DEFAULT_PARQUET_ROW_GROUP_SIZE,
+		PageSize:     DEFAULT_PARQUET_PAGE_SIZE,
+		Parallelism:  1,
+	}
+}
+
+// Builds the options from the values of the command line, the sizes are in MB
+func NewParquetOptions(codec string, rowGroupSize, pageSize, parallelism int) (ParquetOptions, error) {
+	compression, found := parquetCodecs[strings.ToLower(codec)]
+	if !found {
+		return ParquetOptions{}, fmt.Errorf("unknown Parquet codec %q, expected one of uncompressed, snappy, gzip, lz4, zstd", codec)
+	}
+	options := ParquetOptions{
+		Codec:        compression,
+		RowGroupSize: int64(rowGroupSize) * MB,
+		PageSize:     int64(pageSize) * MB,
+		Parallelism:  int64(parallelism),
+	}
+	return options, options.validate()
+}
+
+func (o ParquetOptions) validate() error {
+	if o.RowGroupSize < 1 {
+		return fmt.Errorf("the Parquet row group size must be positive")
+	}
+	if o.PageSize < 1 {
+		return fmt.Errorf("the Parquet page size must be positive")
+	}
+	if o.PageSize > o.RowGroupSize {
+		return fmt.Errorf("the Parquet page size (%d MB) cannot exceed the row group size (%d MB)", o.PageSize/MB, o.RowGroupSize/MB)
+	}
+	if o.Parallelism < 1 {
+		return fmt.Errorf("the Parquet writer parallelism must be at least 1")
+	}
+	if o.MaxPartRows < 0 || o.MaxPartBytes < 0 {
+		return fmt.Errorf("the limits of the Parquet parts cannot be negative")
+	}
+	if o.Partitioned && o.MaxOpenPartitions < 1 {
+		return fmt.Errorf("at least 1 partition must be open")
+	}
+	return nil
+}
+
+// Checks if the output is split in parts
+func (o ParquetOptions) rolling() bool {
+	return o.MaxPartRows > 0 || o.MaxPartBytes > 0
+}
+
+// Creates a Parquet writer of the rows of obj with the options
+func (o ParquetOptions) newWriter(file source.ParquetFile, obj interface{}) (*writer.ParquetWriter, error) {
+	pw, err := writer.NewParquetWriter(file, obj, o.Parallelism)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = o.Codec
+	pw.RowGroupSize = o.RowGroupSize
+	pw.PageSize = o.PageSize
+	return pw, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/xitongsys/parquet-go/parquet"
+	"testing"
+)
+
+func TestNewParquetOptions(t *testing.T) {
+	options, err := NewParquetOptions("ZSTD", 64, 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ParquetOptions{Codec: parquet.CompressionCodec_ZSTD, RowGroupSize: 64 * MB, PageSize: MB, Parallelism: 4}
+	if options != expected {
+		t.Errorf("expected %+v, got %+v", expected, options)
+	}
+
+	if err := DefaultParquetOptions().validate(); err != nil {
+		t.Errorf("invalid default options: %s", err)
+	}
+
+	for _, invalid := range []struct {
+		codec                               string
+		rowGroupSize, pageSize, parallelism int
+	}{
+		{"brotli", 8, 2, 1},
+		{"gzip", 0, 2, 1},
+		{"gzip", 8, 0, 1},
+		{"gzip", 8, 16, 1},
+		{"gzip", 8, 2, 0},
+	} {
+		if _, err := NewParquetOptions(invalid.codec, invalid.rowGroupSize, invalid.pageSize, invalid.parallelism); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+}
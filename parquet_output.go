@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+	"path"
+	"strings"
+)
+
+// Rows and bytes written in an output
+type OutputStats struct {
+	Rows  int64
+	Bytes int64
+}
+
+func (s *OutputStats) add(other OutputStats) {
+	s.Rows += other.Rows
+	s.Bytes += other.Bytes
+}
+
+func (s OutputStats) String() string {
+	return fmt.Sprintf("%d rows, %.1f MB", s.Rows, float64(s.Bytes)/MB)
+}
+
+// Parquet file counting the bytes written
+type countingFile struct {
+	source.ParquetFile
+	written int64
+}
+
+func (f *countingFile) Write(p []byte) (int, error) {
+	n, err := f.ParquetFile.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+// Part of a rolled output, as listed in its manifest
+type ManifestPart struct {
+	File  string `json:"file"`
+	Rows  int64  `json:"rows"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Manifest written next to the parts of a rolled output
+type Manifest struct {
+	Parts []ManifestPart `json:"parts"`
+	Rows  int64          `json:"rows"`
+	Bytes int64          `json:"bytes"`
+}
+
+// Path of the i-th part of the output: out.parquet is split in out-00000.parquet, out-00001.parquet...
+func partPath(destination string, i int) string {
+	return fmt.Sprintf("%s-%05d.parquet", strings.TrimSuffix(destination, ".parquet"), i)
+}
+
+// Path of the manifest of the output: out.manifest.json for out.parquet
+func manifestPath(destination string) string {
+	return strings.TrimSuffix(destination, ".parquet") + ".manifest.json"
+}
+
+// Parquet file of markers, split in parts if the options limit their rows or bytes.
+// Each part is a complete Parquet file, the manifest listing them is written by Close.
+type ParquetOutput struct {
+	destination string
+	options     ParquetOptions
+	httpOptions HttpOptions
+	file        *countingFile
+	pw          *writer.ParquetWriter
+	// Rows of the file being written
+	rows     int64
+	manifest Manifest
+}
+
+// Creates the output and its first file, the S3 requests are retried as set in httpOptions.
+// The errors are *WriterError.
+func NewParquetOutput(destination string, options ParquetOptions, httpOptions HttpOptions) (*ParquetOutput, error) {
+	output := &ParquetOutput{destination: destination, options: options, httpOptions: httpOptions,
+		manifest: Manifest{Parts: []ManifestPart{}}}
+	if err := output.open(); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// Path of the file being written
+func (o *ParquetOutput) currentPath() string {
+	if o.options.rolling() {
+		return partPath(o.destination, len(o.manifest.Parts))
+	}
+	return o.destination
+}
+
+func (o *ParquetOutput) open() error {
+	filePath := o.currentPath()
+	var file source.ParquetFile
+	var err error
+	if isS3Url(filePath) {
+		file, err = (&s3ParquetFile{httpOptions: o.httpOptions}).Create(filePath)
+	} else {
+		file, err = local.NewLocalFileWriter(filePath)
+	}
+	if err != nil {
+		return &WriterError{Destination: filePath, Op: "create", Err: err}
+	}
+	o.file = &countingFile{ParquetFile: file}
+	o.pw, err = o.options.newWriter(o.file, new(Marker))
+	if err != nil {
+		o.file.Close()
+		return &WriterError{Destination: filePath, Op: "create", Err: err}
+	}
+	o.rows = 0
+	return nil
+}
+
+// Finalizes the file being written and adds it to the manifest
+func (o *ParquetOutput) closeFile() error {
+	filePath := o.currentPath()
+	err := o.pw.WriteStop()
+	if closeErr := o.file.Close(); err == nil {
+		err = closeErr
+	}
+	o.manifest.Parts = append(o.manifest.Parts, ManifestPart{File: path.Base(filePath), Rows: o.rows, Bytes: o.file.written})
+	o.manifest.Rows += o.rows
+	o.manifest.Bytes += o.file.written
+	if err != nil {
+		return &WriterError{Destination: filePath, Op: "finalize", Err: err}
+	}
+	return nil
+}
+
+// Checks if the file being written reached the rows or the bytes given, the limits
+// that are 0 are not checked. The bytes are the compressed ones: the bytes written to the
+// file plus the pages of the row group not flushed yet, whose size the writer keeps in
+// Size after compressing them. The last rows, buffered before they fill a page, are not counted.
+func (o *ParquetOutput) reached(maxRows, maxBytes int64) bool {
+	if o.rows == 0 {
+		return false
+	}
+	if maxRows > 0 && o.rows >= maxRows {
+		return true
+	}
+	return maxBytes > 0 && o.file.written+o.pw.Size >= maxBytes
+}
+
+// Writes the marker, moving to the next part first if the current one is full
+func (o *ParquetOutput) Write(marker Marker) error {
+	if o.options.rolling() && o.reached(o.options.MaxPartRows, o.options.MaxPartBytes) {
+		if err := o.closeFile(); err != nil {
+			// The output cannot be written anymore, Close has nothing left to finalize
+			o.pw = nil
+			return err
+		}
+		if err := o.open(); err != nil {
+			o.pw = nil
+			return err
+		}
+	}
+	if err := o.pw.Write(marker); err != nil {
+		return &WriterError{Destination: o.currentPath(), Op: "write", Err: err}
+	}
+	o.rows++
+	return nil
+}
+
+// Writes the markers of the batch, the Parquet writer takes them one row at a time
+func (o *ParquetOutput) WriteBatch(batch *MarkerBatch) error {
+	var marker Marker
+	for i := 0; i < batch.Len(); i++ {
+		batch.Row(i, &marker)
+		if err := o.Write(marker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finalizes the file being written and, for a rolled output, writes the manifest
+func (o *ParquetOutput) Close() error {
+	var err error
+	if o.pw != nil {
+		err = o.closeFile()
+		o.pw = nil
+	}
+	if o.options.rolling() {
+		content, _ := json.MarshalIndent(o.manifest, "", "  ")
+		if writeErr := writeDestination(manifestPath(o.destination), append(content, '\n'), o.httpOptions); writeErr != nil && err == nil {
+			err = &WriterError{Destination: manifestPath(o.destination), Op: "finalize", Err: writeErr}
+		}
+	}
+	return err
+}
+
+// Rows and bytes written in the files finalized
+func (o *ParquetOutput) Stats() OutputStats {
+	return OutputStats{Rows: o.manifest.Rows, Bytes: o.manifest.Bytes}
+}
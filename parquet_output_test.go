@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+// Reads all the markers of a Parquet file
+func readTestParquet(t *testing.T, parquetPath string) []Marker {
+	fr, err := local.NewLocalFileReader(parquetPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+
+	pr, err := reader.NewParquetReader(fr, new(Marker), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	markers := make([]Marker, pr.GetNumRows())
+	if err := pr.Read(&markers); err != nil {
+		t.Fatal(err)
+	}
+	return markers
+}
+
+func TestParquetOutputRolling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultParquetOptions()
+	options.MaxPartRows = 2
+	destination := path.Join(dir, "out.parquet")
+	output, err := NewParquetOutput(destination, options, DefaultHttpOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := output.Write(Marker{Link: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(destination); !os.IsNotExist(err) {
+		t.Errorf("unexpected unsplit output")
+	}
+
+	content, err := ioutil.ReadFile(path.Join(dir, "out.manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Parts) != 3 || manifest.Rows != 5 {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	if stats := output.Stats(); stats.Rows != 5 || stats.Bytes != manifest.Bytes {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	link := 0
+	for i, part := range manifest.Parts {
+		if expected := "out-0000" + strconv.Itoa(i) + ".parquet"; part.File != expected {
+			t.Errorf("expected part %s, got %s", expected, part.File)
+		}
+		info, err := os.Stat(path.Join(dir, part.File))
+		if err != nil || info.Size() != part.Bytes {
+			t.Errorf("part %s: expected %d bytes, got %v %v", part.File, part.Bytes, info, err)
+		}
+		markers := readTestParquet(t, path.Join(dir, part.File))
+		if int64(len(markers)) != part.Rows {
+			t.Errorf("part %s: expected %d rows, got %d", part.File, part.Rows, len(markers))
+		}
+		for _, marker := range markers {
+			if marker.Link != strconv.Itoa(link) {
+				t.Errorf("part %s: expected link %d, got %s", part.File, link, marker.Link)
+			}
+			link++
+		}
+	}
+}
+
+func TestParquetOutputSingleFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	destination := path.Join(dir, "out.parquet")
+	output, err := NewParquetOutput(destination, DefaultParquetOptions(), DefaultHttpOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := output.Write(Marker{Link: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if markers := readTestParquet(t, destination); len(markers) != 3 {
+		t.Errorf("expected 3 rows, got %d", len(markers))
+	}
+	if _, err := os.Stat(path.Join(dir, "out.manifest.json")); !os.IsNotExist(err) {
+		t.Errorf("unexpected manifest of an unsplit output")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default number of Parquet files a partitioned output keeps open at the same time
+const DEFAULT_MAX_OPEN_PARTITIONS = 16
+
+// Value of the partitions whose value is empty, as named by Hive
+const HIVE_DEFAULT_PARTITION = "__HIVE_DEFAULT_PARTITION__"
+
+// Top-level domain partition of the hosts that are IP addresses, it cannot be a real domain
+const IP_TLD_PARTITION = "_ip"
+
+// Directory of the Hive partition of the marker, relative to the root of the output:
+// data_origin=<origin>/year=<yyyy>/month=<mm>/tld=<tld>
+func partitionDir(marker *Marker) string {
+	date := time.Unix(marker.Date, 0).UTC()
+	// The host is inverted, the top-level domain is its first label
+	tld := strings.ToLower(marker.SourceHost)
+	if isInvertedIpHost(tld) {
+		tld = IP_TLD_PARTITION
+	}
+	if dot := strings.IndexByte(tld, '.'); dot >= 0 {
+		tld = tld[:dot]
+	}
+	if colon := strings.IndexByte(tld, ':'); colon >= 0 {
+		tld = tld[:colon]
+	}
+	return path.Join(
+		"data_origin="+escapePartitionValue(marker.DataOrigin),
+		fmt.Sprintf("year=%04d", date.Year()),
+		fmt.Sprintf("month=%02d", int(date.Month())),
+		"tld="+escapePartitionValue(tld))
+}
+
+// Checks if the inverted host, with its port if any, is an IP address: 4:8080.3.2.1 for
+// 1.2.3.4:8080. The IPv6 addresses have no dot, they are kept in brackets.
+func isInvertedIpHost(invertedHost string) bool {
+	if strings.HasPrefix(invertedHost, "[") {
+		return true
+	}
+	labels := strings.Split(invertedHost, ".")
+	if colon := strings.IndexByte(labels[0], ':'); colon >= 0 {
+		labels[0] = labels[0][:colon]
+	}
+	for i := 0; i < len(labels)/2; i++ {
+		j := len(labels) - i - 1
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return net.ParseIP(strings.Join(labels, ".")) != nil
+}
+
+// Escapes the characters of a partition value that are not safe in a path, like Hive does
+func escapePartitionValue(value string) string {
+	if len(value) == 0 {
+		return HIVE_DEFAULT_PARTITION
+	}
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+// Parquet file open in a partition
+type openPartition struct {
+	output *ParquetOutput
+	// Markers written when the file was last used
+	lastUsed int64
+}
+
+// Markers written in Hive-partitioned directories under root, by data origin, month of
+// the capture and top-level domain of the source. The files of a partition are named
+// part-<name>-00000.parquet, part-<name>-00001.parquet... where name identifies the
+// WARC, so that the WARCs of a batch can share the root. At most maxOpen files are open,
+// the least recently used one is closed to open another, and a partition gets a new file
+// when its previous one was closed or reached the limits of a part. Close writes the
+// manifest of the files in root/_<name>.manifest.json, ignored by Spark and Hive.
+type PartitionedOutput struct {
+	root        string
+	name        string
+	options     ParquetOptions
+	httpOptions HttpOptions
+	maxOpen     int
+	open        map[string]*openPartition
+	// Index of the next file of each partition
+	nextPart map[string]int
+	// Limits of the files of a partition, they are not limited if 0
+	maxPartRows  int64
+	maxPartBytes int64
+	written      int64
+	manifest     Manifest
+}
+
+// Creates the output, the files are created when their first marker is written
+func NewPartitionedOutput(root, name string, options ParquetOptions, httpOptions HttpOptions) *PartitionedOutput {
+	maxOpen := options.MaxOpenPartitions
+	if maxOpen < 1 {
+		maxOpen = DEFAULT_MAX_OPEN_PARTITIONS
+	}
+	// The parts of a partition are rolled here, the files are written whole
+	fileOptions := options
+	fileOptions.MaxPartRows = 0
+	fileOptions.MaxPartBytes = 0
+	return &PartitionedOutput{
+		root:         root,
+		name:         name,
+		options:      fileOptions,
+		httpOptions:  httpOptions,
+		maxOpen:      maxOpen,
+		open:         map[string]*openPartition{},
+		nextPart:     map[string]int{},
+		maxPartRows:  options.MaxPartRows,
+		maxPartBytes: options.MaxPartBytes,
+		manifest:     Manifest{Parts: []ManifestPart{}},
+	}
+}
+
+// Writes the marker in the file of its partition
+func (o *PartitionedOutput) Write(marker Marker) error {
+	dir := partitionDir(&marker)
+	partition, found := o.open[dir]
+	if found && partition.output.reached(o.maxPartRows, o.maxPartBytes) {
+		if err := o.closePartition(dir); err != nil {
+			return err
+		}
+		found = false
+	}
+	if !found {
+		if len(o.open) >= o.maxOpen {
+			if err := o.closePartition(o.leastRecentlyUsed()); err != nil {
+				return err
+			}
+		}
+		var err error
+		if partition, err = o.openPartition(dir); err != nil {
+			return err
+		}
+	}
+	o.written++
+	partition.lastUsed = o.written
+	return partition.output.Write(marker)
+}
+
+// Writes each marker of the batch in the file of its partition
+func (o *PartitionedOutput) WriteBatch(batch *MarkerBatch) error {
+	var marker Marker
+	for i := 0; i < batch.Len(); i++ {
+		batch.Row(i, &marker)
+		if err := o.Write(marker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Partition whose file has not been written for the longest time
+func (o *PartitionedOutput) leastRecentlyUsed() string {
+	oldest := ""
+	for dir, partition := range o.open {
+		if len(oldest) == 0 || partition.lastUsed < o.open[oldest].lastUsed {
+			oldest = dir
+		}
+	}
+	return oldest
+}
+
+// Path of the i-th file of the partition, relative to the root
+func (o *PartitionedOutput) filePath(dir string, i int) string {
+	return path.Join(dir, fmt.Sprintf("part-%s-%05d.parquet", o.name, i))
+}
+
+func (o *PartitionedOutput) openPartition(dir string) (*openPartition, error) {
+	if err := makeDestinationDir(joinOutputPath(o.root, dir)); err != nil {
+		return nil, &WriterError{Destination: joinOutputPath(o.root, dir), Op: "create", Err: err}
+	}
+	output, err := NewParquetOutput(joinOutputPath(o.root, o.filePath(dir, o.nextPart[dir])), o.options, o.httpOptions)
+	if err != nil {
+		return nil, err
+	}
+	partition := &openPartition{output: output}
+	o.open[dir] = partition
+	return partition, nil
+}
+
+// Finalizes the file of the partition and adds it to the manifest
+func (o *PartitionedOutput) closePartition(dir string) error {
+	partition := o.open[dir]
+	delete(o.open, dir)
+	err := partition.output.Close()
+	for _, part := range partition.output.manifest.Parts {
+		part.File = o.filePath(dir, o.nextPart[dir])
+		o.manifest.Parts = append(o.manifest.Parts, part)
+		o.manifest.Rows += part.Rows
+		o.manifest.Bytes += part.Bytes
+	}
+	o.nextPart[dir]++
+	return err
+}
+
+// Finalizes the open files and writes the manifest. The files are closed in the order
+// of their partitions, so that the manifest does not depend on the order of the map.
+func (o *PartitionedOutput) Close() error {
+	dirs := make([]string, 0, len(o.open))
+	for dir := range o.open {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var err error
+	for _, dir := range dirs {
+		if closeErr := o.closePartition(dir); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	manifestFile := joinOutputPath(o.root, "_"+o.name+".manifest.json")
+	content, _ := json.MarshalIndent(o.manifest, "", "  ")
+	if writeErr := writeDestination(manifestFile, append(content, '\n'), o.httpOptions); writeErr != nil && err == nil {
+		err = &WriterError{Destination: manifestFile, Op: "finalize", Err: writeErr}
+	}
+	return err
+}
+
+// Rows and bytes written in the files finalized
+func (o *PartitionedOutput) Stats() OutputStats {
+	return OutputStats{Rows: o.manifest.Rows, Bytes: o.manifest.Bytes}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPartitionDir(t *testing.T) {
+	date := time.Date(2019, 7, 27, 10, 0, 0, 0, time.UTC).Unix()
+	for _, test := range []struct {
+		marker   Marker
+		expected string
+	}{
+		{Marker{Date: date, SourceHost: "com.example.www", DataOrigin: "cc"},
+			"data_origin=cc/year=2019/month=07/tld=com"},
+		{Marker{Date: date, SourceHost: "IT:8080.example", DataOrigin: "crawl 2019/07"},
+			"data_origin=crawl%202019%2F07/year=2019/month=07/tld=it"},
+		{Marker{Date: date, DataOrigin: "cc"},
+			"data_origin=cc/year=2019/month=07/tld=" + HIVE_DEFAULT_PARTITION},
+		// IP addresses, inverted with their port
+		{Marker{Date: date, SourceHost: "4.3.2.1", DataOrigin: "cc"},
+			"data_origin=cc/year=2019/month=07/tld=" + IP_TLD_PARTITION},
+		{Marker{Date: date, SourceHost: "4:8080.3.2.1", DataOrigin: "cc"},
+			"data_origin=cc/year=2019/month=07/tld=" + IP_TLD_PARTITION},
+		{Marker{Date: date, SourceHost: "[2001:db8::1]:8080", DataOrigin: "cc"},
+			"data_origin=cc/year=2019/month=07/tld=" + IP_TLD_PARTITION},
+		{Marker{Date: date, SourceHost: "com.example.1", DataOrigin: "cc"},
+			"data_origin=cc/year=2019/month=07/tld=com"},
+	} {
+		if dir := partitionDir(&test.marker); dir != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, dir)
+		}
+	}
+}
+
+func TestPartitionedOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-partitions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	july := time.Date(2019, 7, 27, 10, 0, 0, 0, time.UTC).Unix()
+	august := time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC).Unix()
+	markers := []Marker{
+		{Date: july, SourceHost: "com.example", DataOrigin: "cc", Link: "1"},
+		{Date: july, SourceHost: "org.example", DataOrigin: "cc", Link: "2"},
+		// The file of com is closed to open the one of org, a new one is opened
+		{Date: july, SourceHost: "com.example", DataOrigin: "cc", Link: "3"},
+		{Date: july, SourceHost: "com.example", DataOrigin: "cc", Link: "4"},
+		// Rolled at 2 rows
+		{Date: july, SourceHost: "com.example", DataOrigin: "cc", Link: "5"},
+		{Date: august, SourceHost: "com.example", DataOrigin: "cc", Link: "6"},
+	}
+
+	options := DefaultParquetOptions()
+	options.Partitioned = true
+	options.MaxOpenPartitions = 1
+	options.MaxPartRows = 2
+	output := NewPartitionedOutput(dir, "test.warc.gz", options, DefaultHttpOptions())
+	for _, marker := range markers {
+		if err := output.Write(marker); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(path.Join(dir, "_test.warc.gz.manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		file  string
+		links []string
+	}{
+		{"data_origin=cc/year=2019/month=07/tld=com/part-test.warc.gz-00000.parquet", []string{"1"}},
+		{"data_origin=cc/year=2019/month=07/tld=org/part-test.warc.gz-00000.parquet", []string{"2"}},
+		{"data_origin=cc/year=2019/month=07/tld=com/part-test.warc.gz-00001.parquet", []string{"3", "4"}},
+		{"data_origin=cc/year=2019/month=07/tld=com/part-test.warc.gz-00002.parquet", []string{"5"}},
+		{"data_origin=cc/year=2019/month=08/tld=com/part-test.warc.gz-00000.parquet", []string{"6"}},
+	}
+	if len(manifest.Parts) != len(expected) || manifest.Rows != int64(len(markers)) {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	for i, part := range manifest.Parts {
+		if part.File != expected[i].file {
+			t.Errorf("expected %s, got %s", expected[i].file, part.File)
+			continue
+		}
+		written := readTestParquet(t, path.Join(dir, part.File))
+		if len(written) != len(expected[i].links) || int64(len(written)) != part.Rows {
+			t.Errorf("%s: unexpected rows %+v", part.File, written)
+			continue
+		}
+		for j, marker := range written {
+			if marker.Link != expected[i].links[j] {
+				t.Errorf("%s: expected link %s, got %s", part.File, expected[i].links[j], marker.Link)
+			}
+		}
+	}
+	if stats := output.Stats(); stats.Rows != int64(len(markers)) || stats.Bytes != manifest.Bytes {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestPartitionedOutputInterleaved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-partitions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := DefaultParquetOptions()
+	options.Partitioned = true
+	options.MaxOpenPartitions = 2
+	output := NewPartitionedOutput(dir, "test.warc.gz", options, DefaultHttpOptions())
+	date := time.Date(2019, 7, 27, 10, 0, 0, 0, time.UTC).Unix()
+
+	// Runs of 3 rows of each partition, the third partition closes the least recently used file
+	hosts := []string{"com.example", "org.example", "net.example"}
+	const runs, runRows = 4, 3
+	for run := 0; run < runs; run++ {
+		for _, host := range hosts {
+			for i := 0; i < runRows; i++ {
+				marker := Marker{Date: date, SourceHost: host, DataOrigin: "cc", Link: strconv.Itoa(run*runRows + i)}
+				if err := output.Write(marker); err != nil {
+					t.Fatal(err)
+				}
+				if len(output.open) > options.MaxOpenPartitions {
+					t.Fatalf("%d files open", len(output.open))
+				}
+			}
+		}
+	}
+	if err := output.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new part for each run, the rows of a partition are in order across its parts
+	if len(output.manifest.Parts) != runs*len(hosts) || output.manifest.Rows != runs*runRows*int64(len(hosts)) {
+		t.Fatalf("unexpected manifest %+v", output.manifest)
+	}
+	links := map[string][]string{}
+	for _, part := range output.manifest.Parts {
+		written := readTestParquet(t, path.Join(dir, part.File))
+		if len(written) != runRows {
+			t.Fatalf("%s: expected %d rows, got %d", part.File, runRows, len(written))
+		}
+		for _, marker := range written {
+			links[path.Dir(part.File)] = append(links[path.Dir(part.File)], marker.Link)
+		}
+	}
+	for partition, partitionLinks := range links {
+		for i, link := range partitionLinks {
+			if link != strconv.Itoa(i) {
+				t.Fatalf("%s: row %d is %s", partition, i, link)
+			}
+		}
+	}
+}
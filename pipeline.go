@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// HTML page of a response record waiting to be parsed
+type htmlPage struct {
+	pageUrl           *url.URL
+	normalizedPageUrl string
+	invertedPageHost  string
+	response          *HttpResponse
+	// Body as stored in the record, with its transfer and content encodings
+	body []byte
+}
+
+// A record on its way from the reader to the collector
+type recordResult struct {
+	// Position of the record in the WARC, counting only the records read without errors
+	sequence int
+	header   WarcHeader
+	// Markers found in the headers of the record
+	markers []Marker
+	// Page marker of responses and revisits
+	page *Marker
+	// HTML page to parse, the parser replaces it with the links
+	html  *htmlPage
+	links *MarkerBatch
+	// The links of the original capture should be copied to the revisit
+	copyRevisit bool
+	// Crawl information of request and metadata records
+	info *crawlInfo
+	// Bytes taken from the memory budget by the reader
+	size int64
+}
+
+// Bytes of HTML assumed for each link of a page that is not parsed yet, as in a page
+// made only of short links. The estimate is replaced by the size of the links once parsed.
+const HTML_BYTES_PER_LINK = 32
+
+// Approximate number of bytes the record takes in memory until its markers are moved
+// to a chunk. The links of an HTML page not parsed yet are estimated from its body.
+func (result *recordResult) estimatedSize() int64 {
+	var size int64
+	for i := range result.markers {
+		size += result.markers[i].estimatedSize()
+	}
+	for key, value := range result.header {
+		size += int64(len(key) + len(value))
+	}
+	if result.page != nil {
+		size += result.page.estimatedSize()
+	}
+	if result.html != nil {
+		body := int64(len(result.html.body))
+		// The body, and the strings of the links taken from it
+		size += 2*body + body/HTML_BYTES_PER_LINK*markerRowSize
+	}
+	if result.links != nil {
+		size += result.links.size
+	}
+	return size
+}
+
+// Parses the HTML pages of the records received until the channel is closed
+// and sends the records with their links to the collector. The estimate of the
+// page taken from the budget is replaced by the size of its links.
+func ParseRecords(recordsChannel chan *recordResult, parsedChannel chan *recordResult, budget *MemoryBudget,
+	logger Logger, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for result := range recordsChannel {
+		if result.html != nil {
+			parseRecord(result, budget, logger)
+		}
+		parsedChannel <- result
+	}
+}
+
+// Replaces the HTML page of the record with its links. A panic of the parser is logged
+// as a malformed record and the page is forwarded without links, the other records
+// parsed by the same goroutine are not lost and the pipeline can drain.
+func parseRecord(result *recordResult, budget *MemoryBudget, logger Logger) {
+	page := result.html
+	defer func() {
+		if r := recover(); r != nil {
+			recordErr := &RecordError{Offset: result.page.WarcOffset, RecordID: result.page.WarcRecordId,
+				Err: fmt.Errorf("parser panic: %v", r)}
+			logger.Exceptions <- Exception{
+				SourcePage:      page.normalizedPageUrl,
+				ErrorType:       "Record malformed",
+				Message:         fmt.Sprintf("Record %s at offset %d", recordErr.RecordID, recordErr.Offset),
+				OriginalMessage: recordErr.Err.Error(),
+			}
+			// The partly filled links go back to the pool, update gives back their bytes
+			if result.links != nil {
+				result.links.Release()
+				result.links = nil
+			}
+		}
+		result.html = nil
+
+		size := result.estimatedSize()
+		budget.update(result.size, size)
+		result.size = size
+	}()
+
+	body := page.response.DecodeBody(bufio.NewReader(bytes.NewReader(page.body)))
+	contentType := page.response.Header.Get("Content-Type")
+	customReader := getCharsetReader(bufio.NewReader(body), contentType)
+
+	pageLinks, canonical := getLinks(result.page.DataOrigin, result.page.Date, page.pageUrl, &page.normalizedPageUrl,
+		customReader, logger, page.invertedPageHost)
+	result.links = pageLinks
+	pageLinks.setWarcRecord(result.page.WarcFile, result.page.WarcOffset)
+	result.page.Canonical = canonical
+}
+
+// Collects the markers of the parsed records, in the order of the records unless config.Unordered
+// is set, and sends them to the writer in chunks. The revisits get the links of their original
+// capture and the request and metadata records are joined to their response here, as they
+// need the records seen before. The bytes of a response are held in the budget until its
+// markers are moved to a chunk, the ones of the other records are given back when they are
+// collected, and the chunks take their share until they are written.
+// It signals on done after the last chunk has been sent.
+func CollectMarkers(parsedChannel chan *recordResult, writersChannel chan *MarkerBatch, config ExtractionConfig, done chan bool) {
+
+	budget := config.MemoryBudget
+	markersBuffer := NewMarkerBatch()
+	originals := newOriginalCaptures(budget)
+	joiner := newRecordJoiner()
+
+	// Moves the chunk to the writer, its bytes are released once written
+	send := func() {
+		budget.unhold(markersBuffer.size)
+		writersChannel <- markersBuffer
+	}
+
+	collect := func(result *recordResult) {
+		bufferedSize := markersBuffer.size
+		releasedSize := joiner.next(markersBuffer)
+		budget.unhold(releasedSize)
+		budget.release(releasedSize)
+		budget.add(markersBuffer.size - bufferedSize)
+		budget.hold(markersBuffer.size - bufferedSize)
+
+		if result.info != nil {
+			joiner.addInfo(result.header, *result.info)
+		}
+		if page := result.page; page != nil {
+			if result.links != nil && config.CopyRevisitLinks {
+				originals.add(page, result.links, page.Canonical)
+			}
+			if result.copyRevisit {
+				if result.links == nil {
+					result.links = NewMarkerBatch()
+				}
+				page.Canonical = originals.copyLinks(page, result.header.Get("WARC-Refers-To"), result.links)
+			}
+			// Released when its request and metadata are joined, the links copied
+			// from the original capture are counted as well
+			size := result.estimatedSize()
+			budget.update(result.size, size)
+			budget.hold(size)
+			joiner.addPage(result.header, result.markers, result.links, page, size)
+		} else {
+			budget.release(result.size)
+		}
+
+		if markersBuffer.size >= budget.chunkSize() {
+			// Send the chunk and take a new batch, the writer gives it back to the pool
+			send()
+			markersBuffer = NewMarkerBatch()
+		}
+	}
+
+	// Records parsed before the ones preceding them
+	waiting := make(map[int]*recordResult)
+	nextSequence := 0
+
+	for result := range parsedChannel {
+		if config.Unordered {
+			collect(result)
+			continue
+		}
+
+		waiting[result.sequence] = result
+		for {
+			next, found := waiting[nextSequence]
+			if !found {
+				break
+			}
+			delete(waiting, nextSequence)
+			collect(next)
+			nextSequence++
+		}
+	}
+
+	bufferedSize := markersBuffer.size
+	releasedSize := joiner.flush(markersBuffer)
+	budget.unhold(releasedSize)
+	budget.release(releasedSize)
+	budget.add(markersBuffer.size - bufferedSize)
+	budget.hold(markersBuffer.size - bufferedSize)
+	originals.clear()
+	send()
+	done <- true
+}
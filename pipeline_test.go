@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tevino/abool"
+)
+
+func TestParallelParsing(t *testing.T) {
+	var warc strings.Builder
+	for i := 0; i < 200; i++ {
+		var page strings.Builder
+		page.WriteString("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<html>")
+		// Pages of different sizes, so that the parsers complete them out of order
+		for j := 0; j < (i*7)%23; j++ {
+			fmt.Fprintf(&page, "<a href=\"/page-%d/link-%d\">link</a>", i, j)
+		}
+		page.WriteString("</html>")
+		warc.WriteString(testWarcResponse(fmt.Sprintf("http://example.com/page-%d", i), page.String()))
+		if i%10 == 0 {
+			warc.WriteString(testWarcResponse(fmt.Sprintf("http://example.com/missing-%d", i), "HTTP/1.1 404 Not Found\r\n\r\n"))
+		}
+	}
+
+	sequential := readTestWarcWithConfig(t, warc.String(), ExtractionConfig{ParsersCount: 1})
+	if len(sequential) == 0 {
+		t.Fatal("no markers extracted")
+	}
+
+	parallel := readTestWarcWithConfig(t, warc.String(), ExtractionConfig{ParsersCount: 8})
+	if len(parallel) != len(sequential) {
+		t.Fatalf("expected %d markers, got %d", len(sequential), len(parallel))
+	}
+	for i := range sequential {
+		if parallel[i] != sequential[i] {
+			t.Fatalf("marker %d: got %+v, expected %+v", i, parallel[i], sequential[i])
+		}
+	}
+
+	// A budget smaller than any record lets through a record at a time
+	budget := NewMemoryBudget(1)
+	bounded := readTestWarcWithConfig(t, warc.String(), ExtractionConfig{ParsersCount: 8, MemoryBudget: budget})
+	if len(bounded) != len(sequential) || budget.Used() != 0 {
+		t.Fatalf("expected %d markers and an empty budget, got %d and %d bytes", len(sequential), len(bounded), budget.Used())
+	}
+
+	unordered := readTestWarcWithConfig(t, warc.String(), ExtractionConfig{ParsersCount: 8, Unordered: true})
+	if len(unordered) != len(sequential) {
+		t.Fatalf("expected %d unordered markers, got %d", len(sequential), len(unordered))
+	}
+	key := func(marker Marker) string {
+		return marker.Source + " " + marker.Link + " " + marker.Tag
+	}
+	var expectedKeys, keys []string
+	for i := range sequential {
+		expectedKeys = append(expectedKeys, key(sequential[i]))
+		keys = append(keys, key(unordered[i]))
+	}
+	sort.Strings(expectedKeys)
+	sort.Strings(keys)
+	if strings.Join(keys, "\n") != strings.Join(expectedKeys, "\n") {
+		t.Errorf("the unordered markers differ from the ordered ones")
+	}
+}
+
+func TestMemoryBudgetLinkHeavyPages(t *testing.T) {
+	const pages = 60
+	const linksPerPage = 400
+	var warc strings.Builder
+	for i := 0; i < pages; i++ {
+		var page strings.Builder
+		page.WriteString("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<html>")
+		for j := 0; j < linksPerPage; j++ {
+			fmt.Fprintf(&page, "<a href=\"/section/page-%d-%d.html\">Title</a>\n", i, j)
+		}
+		page.WriteString("</html>")
+		warc.WriteString(testWarcResponse(fmt.Sprintf("http://example.com/page-%d", i), page.String()))
+	}
+
+	budget := NewMemoryBudget(2 * MB)
+	markers := readTestWarcWithConfig(t, warc.String(), ExtractionConfig{ParsersCount: 4, MemoryBudget: budget})
+	if len(markers) != pages*(linksPerPage+1) {
+		t.Fatalf("expected %d markers, got %d", pages*(linksPerPage+1), len(markers))
+	}
+	if budget.Used() != 0 {
+		t.Errorf("expected an empty budget, %d bytes are left", budget.Used())
+	}
+	// The links are estimated before the pages are parsed
+	if budget.Peak() > budget.Limit() {
+		t.Errorf("the budget peaked at %d bytes, over its limit of %d", budget.Peak(), budget.Limit())
+	}
+}
+
+func TestCollectMarkersBudget(t *testing.T) {
+	budget := NewMemoryBudget(DEFAULT_MEMORY_BUDGET)
+	parsedChannel := make(chan *recordResult)
+	writersChannel := make(chan *MarkerBatch, JOIN_WINDOW_RECORDS+2)
+	done := make(chan bool, 1)
+	go CollectMarkers(parsedChannel, writersChannel, ExtractionConfig{MemoryBudget: budget}, done)
+
+	// Pages read as the reader does, the last one is sent once the previous ones are collected
+	var windowSize int64
+	for i := 0; i <= JOIN_WINDOW_RECORDS; i++ {
+		page := NewWebpageMarker(1600000000, "com.example", false, fmt.Sprintf("http://example.com/page%d", i), "200", "", "test")
+		result := &recordResult{sequence: i, page: &page, links: NewMarkerBatch()}
+		for _, link := range testPageLinks(i, 100) {
+			result.links.Append(&link)
+		}
+		result.size = result.estimatedSize()
+		if i < JOIN_WINDOW_RECORDS {
+			windowSize += result.size
+		}
+		budget.acquire(result.size)
+		parsedChannel <- result
+	}
+
+	// The pages are in the join window, their links are not in a chunk yet
+	if len(writersChannel) != 0 || budget.Used() < windowSize {
+		t.Errorf("expected the %d bytes of the join window to be held, %d used", windowSize, budget.Used())
+	}
+
+	close(parsedChannel)
+	<-done
+	close(writersChannel)
+	rows := 0
+	for chunk := range writersChannel {
+		rows += chunk.Len()
+		budget.release(chunk.size)
+		chunk.Release()
+	}
+	if rows != (JOIN_WINDOW_RECORDS+1)*101 || budget.Used() != 0 {
+		t.Errorf("expected %d rows and an empty budget, got %d rows and %d bytes", (JOIN_WINDOW_RECORDS+1)*101, rows, budget.Used())
+	}
+}
+
+func TestParseRecordsPanic(t *testing.T) {
+	budget := NewMemoryBudget(DEFAULT_MEMORY_BUDGET)
+	logger := Logger{Exceptions: make(chan Exception, 1)}
+	recordsChannel := make(chan *recordResult, 2)
+	parsedChannel := make(chan *recordResult, 2)
+
+	// The page has no HTTP response, the parser dereferences it
+	page := NewWebpageMarker(1600000000, "com.example", false, "http://example.com/broken", "200", "", "test")
+	page.WarcOffset = 1234
+	page.WarcRecordId = "<urn:uuid:broken>"
+	broken := &recordResult{sequence: 0, page: &page, html: &htmlPage{normalizedPageUrl: page.Source, body: []byte("<html>")}}
+	// Links found before the panic
+	broken.links = NewMarkerBatch()
+	for _, link := range testPageLinks(0, 10) {
+		broken.links.Append(&link)
+	}
+	broken.size = broken.estimatedSize()
+	budget.acquire(broken.size)
+	next := &recordResult{sequence: 1}
+	budget.acquire(next.size)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	recordsChannel <- broken
+	recordsChannel <- next
+	close(recordsChannel)
+	ParseRecords(recordsChannel, parsedChannel, budget, logger, &wg)
+
+	// Both records are forwarded, the broken page without links
+	if len(parsedChannel) != 2 {
+		t.Fatalf("expected 2 records forwarded, got %d", len(parsedChannel))
+	}
+	if result := <-parsedChannel; result != broken || result.html != nil || result.links != nil || budget.Used() != result.size {
+		t.Errorf("unexpected forwarded record %+v, %d bytes used", result, budget.Used())
+	}
+
+	exception := <-logger.Exceptions
+	if exception.ErrorType != "Record malformed" || exception.SourcePage != page.Source ||
+		exception.Message != "Record <urn:uuid:broken> at offset 1234" || !strings.HasPrefix(exception.OriginalMessage, "parser panic: ") {
+		t.Errorf("unexpected exception %+v", exception)
+	}
+}
+
+// Returns the content, then panics instead of returning io.EOF
+type panickingReader struct {
+	content io.Reader
+}
+
+func (r *panickingReader) Read(p []byte) (int, error) {
+	n, err := r.content.Read(p)
+	if err == io.EOF {
+		panic("reader failure")
+	}
+	return n, err
+}
+
+func TestReadWarcPanic(t *testing.T) {
+	var warc strings.Builder
+	for i := 0; i < 20; i++ {
+		warc.WriteString(testWarcResponse(fmt.Sprintf("http://example.com/page-%d", i),
+			fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<a href=\"/link-%d\">link</a>", i)))
+	}
+	expected := readTestWarcWithConfig(t, warc.String(), ExtractionConfig{ParsersCount: 4})
+
+	budget := NewMemoryBudget(DEFAULT_MEMORY_BUDGET)
+	logger := Logger{Exceptions: make(chan Exception, 100)}
+	recordsReader, err := NewWarcReader(&panickingReader{strings.NewReader(warc.String())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writerChannel := make(chan *MarkerBatch, len(expected))
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("expected the panic of the reader")
+			}
+		}()
+		ReadWarc("test", "test.warc", recordsReader, writerChannel, abool.New(), ExtractionConfig{ParsersCount: 4, MemoryBudget: budget}, logger)
+	}()
+
+	// The parsers and the collector stopped and sent their markers before the panic reached the caller
+	close(writerChannel)
+	var markers []Marker
+	for chunk := range writerChannel {
+		markers = append(markers, batchMarkers(chunk)...)
+		budget.release(chunk.size)
+		chunk.Release()
+	}
+	// The panic happens reading the last record, the ones before are collected
+	if len(markers) == 0 || len(markers) >= len(expected) || budget.Used() != 0 {
+		t.Fatalf("expected less than %d markers and an empty budget, got %d and %d bytes", len(expected), len(markers), budget.Used())
+	}
+	for i := range markers {
+		if markers[i] != expected[i] {
+			t.Fatalf("marker %d: got %+v, expected %+v", i, markers[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Rows read at once from the Parquet files
+const REDIRECTS_READ_BATCH = 100000
+
+const SECONDS_PER_DAY = 24 * 60 * 60
+
+// Outcomes of the resolution of a redirect chain
+const (
+	REDIRECT_RESOLVED = "resolved"
+	REDIRECT_LOOP     = "loop"
+	REDIRECT_TOO_LONG = "too_long"
+)
+
+// HTTP codes of the redirects followed in a chain
+var redirectCodes = map[string]bool{"301": true, "302": true, "303": true, "307": true, "308": true}
+
+// Columns of the Marker needed to resolve the redirects
+type redirectMarker struct {
+	Date       int64  `parquet:"name=date, type=INT64"`
+	Source     string `parquet:"name=source, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Link       string `parquet:"name=link, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Tag        string `parquet:"name=tag, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Extras     string `parquet:"name=extras, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	DataOrigin string `parquet:"name=data_origin, type=UTF8, encoding=PLAIN_DICTIONARY"`
+}
+
+// A row of the redirect-resolution table
+type RedirectResolution struct {
+	// First second of the crawl day (UTC) the chain was captured
+	Date       int64  `parquet:"name=date, type=INT64"`
+	DataOrigin string `parquet:"name=data_origin, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Source     string `parquet:"name=source, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	// Last URL reached, it is the URL where the chain stopped for loops and too long chains
+	Final string `parquet:"name=final, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Hops  int32  `parquet:"name=hops, type=INT32"`
+	// HTTP codes of the hops separated by commas, e.g. "301,302"
+	Codes  string `parquet:"name=codes, type=UTF8, encoding=PLAIN_DICTIONARY"`
+	Status string `parquet:"name=status, type=UTF8, encoding=PLAIN_DICTIONARY"`
+}
+
+// Redirects are only followed within the same crawl and day
+type redirectScope struct {
+	DataOrigin string
+	Day        int64
+}
+
+type redirectHop struct {
+	Target string
+	Code   string
+	Date   int64
+}
+
+// Reads the redirects from the Sequencer outputs, follows the chains and writes
+// one row for each redirected URL in the output Parquet. It returns the number of rows.
+// The inputs can be rolled or partitioned outputs, see redirectInputFiles.
+func ResolveRedirects(inputParquets []string, outputParquet string, maxHops int) (int, error) {
+
+	redirects := make(map[redirectScope]map[string]redirectHop)
+	for _, inputParquet := range inputParquets {
+		files, err := redirectInputFiles(inputParquet)
+		if err != nil {
+			return 0, &InputError{Path: inputParquet, Err: err}
+		}
+		for _, file := range files {
+			if err := loadRedirects(file, redirects); err != nil {
+				return 0, &InputError{Path: file, Err: err}
+			}
+		}
+	}
+
+	resolutions := resolveRedirectChains(redirects, maxHops)
+
+	fw, err := local.NewLocalFileWriter(outputParquet)
+	if err != nil {
+		return 0, &WriterError{Destination: outputParquet, Op: "create", Err: err}
+	}
+	pw, err := writer.NewParquetWriter(fw, new(RedirectResolution), 1)
+	if err != nil {
+		fw.Close()
+		return 0, &WriterError{Destination: outputParquet, Op: "create", Err: err}
+	}
+	pw.CompressionType = parquet.CompressionCodec_GZIP
+
+	for i := range resolutions {
+		if err := pw.Write(&resolutions[i]); err != nil {
+			pw.WriteStop()
+			fw.Close()
+			return 0, &WriterError{Destination: outputParquet, Op: "write", Err: err}
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		fw.Close()
+		return 0, &WriterError{Destination: outputParquet, Op: "finalize", Err: err}
+	}
+	if err := fw.Close(); err != nil {
+		return 0, &WriterError{Destination: outputParquet, Op: "finalize", Err: err}
+	}
+	return len(resolutions), nil
+}
+
+// Parquet files of an input of the resolution, which is one of:
+// - a Parquet file
+// - a rolled output, by its destination (out.parquet for out-00000.parquet...) or its manifest
+// - the manifest of a partitioned output, _<name>.manifest.json in its root
+// - a directory, such as the root of a partitioned output, whose Parquet files are read
+// recursively. The files and directories starting with _ or . are skipped as in Hive.
+// Only the local files are supported.
+func redirectInputFiles(input string) ([]string, error) {
+	if isS3Url(input) || isHttpUrl(input) {
+		return nil, fmt.Errorf("unsupported source, only local files can be resolved")
+	}
+	if strings.HasSuffix(input, ".manifest.json") {
+		return manifestFiles(input)
+	}
+	info, err := os.Stat(input)
+	if os.IsNotExist(err) {
+		// The destination of a rolled output, only its parts and manifest exist
+		if _, manifestErr := os.Stat(manifestPath(input)); manifestErr == nil {
+			return manifestFiles(manifestPath(input))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{input}, nil
+	}
+
+	var files []string
+	err = filepath.Walk(input, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if file != input && (strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".")) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && strings.HasSuffix(name, ".parquet") {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Parquet file in the directory")
+	}
+	return files, nil
+}
+
+// Files listed in a manifest, their paths are relative to the directory of the manifest
+func manifestFiles(manifestFile string) ([]string, error) {
+	content, err := ioutil.ReadFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %s", err)
+	}
+	files := make([]string, len(manifest.Parts))
+	for i, part := range manifest.Parts {
+		files[i] = path.Join(path.Dir(manifestFile), part.File)
+	}
+	return files, nil
+}
+
+// Adds the 3xx page markers of the Parquet file to the redirects.
+// When a URL is captured more than once in a day, the latest capture wins.
+func loadRedirects(inputParquet string, redirects map[redirectScope]map[string]redirectHop) error {
+	fr, err := local.NewLocalFileReader(inputParquet)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	pr, err := reader.NewParquetReader(fr, new(redirectMarker), 1)
+	if err != nil {
+		return err
+	}
+	defer pr.ReadStop()
+
+	rowsCount := int(pr.GetNumRows())
+	for read := 0; read < rowsCount; read += REDIRECTS_READ_BATCH {
+		batchSize := rowsCount - read
+		if batchSize > REDIRECTS_READ_BATCH {
+			batchSize = REDIRECTS_READ_BATCH
+		}
+		markers := make([]redirectMarker, batchSize)
+		if err := pr.Read(&markers); err != nil {
+			return err
+		}
+
+		for _, marker := range markers {
+			// Page markers have no link, the tag is the HTTP code and the extras the target
+			if len(marker.Link) > 0 || !redirectCodes[marker.Tag] || len(marker.Extras) == 0 {
+				continue
+			}
+			scope := redirectScope{DataOrigin: marker.DataOrigin, Day: marker.Date - marker.Date%SECONDS_PER_DAY}
+			scopeRedirects, found := redirects[scope]
+			if !found {
+				scopeRedirects = make(map[string]redirectHop)
+				redirects[scope] = scopeRedirects
+			}
+			if previous, found := scopeRedirects[marker.Source]; !found || previous.Date <= marker.Date {
+				scopeRedirects[marker.Source] = redirectHop{Target: marker.Extras, Code: marker.Tag, Date: marker.Date}
+			}
+		}
+	}
+	return nil
+}
+
+// Follows the chain of each redirected URL. The result is sorted by scope and source URL.
+// A redirect of a URL to itself ends the chain as resolved, it only changes the scheme.
+func resolveRedirectChains(redirects map[redirectScope]map[string]redirectHop, maxHops int) []RedirectResolution {
+
+	scopes := make([]redirectScope, 0, len(redirects))
+	for scope := range redirects {
+		scopes = append(scopes, scope)
+	}
+	sort.Slice(scopes, func(i, j int) bool {
+		if scopes[i].DataOrigin != scopes[j].DataOrigin {
+			return scopes[i].DataOrigin < scopes[j].DataOrigin
+		}
+		return scopes[i].Day < scopes[j].Day
+	})
+
+	var resolutions []RedirectResolution
+	for _, scope := range scopes {
+		scopeRedirects := redirects[scope]
+
+		sources := make([]string, 0, len(scopeRedirects))
+		for source := range scopeRedirects {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+
+		for _, source := range sources {
+			resolution := RedirectResolution{
+				Date:       scope.Day,
+				DataOrigin: scope.DataOrigin,
+				Source:     source,
+				Status:     REDIRECT_RESOLVED,
+			}
+
+			var codes []string
+			visited := map[string]bool{source: true}
+			current := source
+			for {
+				hop, found := scopeRedirects[current]
+				if !found {
+					break
+				}
+				if len(codes) >= maxHops {
+					resolution.Status = REDIRECT_TOO_LONG
+					break
+				}
+				codes = append(codes, hop.Code)
+				if hop.Target == current {
+					// The URLs are normalized without their scheme, a redirect to the same URL
+					// is the move of the page to the other scheme, such as http://x to https://x/
+					break
+				}
+				current = hop.Target
+				if visited[current] {
+					resolution.Status = REDIRECT_LOOP
+					break
+				}
+				visited[current] = true
+			}
+
+			resolution.Final = current
+			resolution.Hops = int32(len(codes))
+			resolution.Codes = strings.Join(codes, ",")
+			resolutions = append(resolutions, resolution)
+		}
+	}
+	return resolutions
+}
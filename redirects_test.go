@@ -0,0 +1,172 @@
+package main
+
+import (
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func writeTestParquet(t *testing.T, destination string, markers []Marker) {
+	fw, err := local.NewLocalFileWriter(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := writer.NewParquetWriter(fw, new(Marker), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range markers {
+		if err := pw.Write(&markers[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	fw.Close()
+}
+
+func TestResolveRedirects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-redirects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	day := int64(1564185600) // 2019-07-27
+	page := func(date int64, source, code, target string) Marker {
+		return NewWebpageMarker(date, "", false, source, code, target, "test")
+	}
+
+	writeTestParquet(t, path.Join(dir, "first.parquet"), []Marker{
+		page(day+10, "http://a.com", "301", "http://b.com"),
+		page(day+20, "http://b.com", "302", "http://c.com"),
+		page(day+30, "http://c.com", "200", ""),
+		page(day+40, "http://loop1.com", "307", "http://loop2.com"),
+		// http://upgrade.com to https://upgrade.com/, the same URL once normalized
+		page(day+45, "http://old.com", "301", "http://upgrade.com"),
+		page(day+45, "http://upgrade.com", "301", "http://upgrade.com"),
+		NewMarker(day+40, "", false, "http://c.com", "http://z.com", "", "a", "", "test"),
+	})
+	writeTestParquet(t, path.Join(dir, "second.parquet"), []Marker{
+		page(day+50, "http://loop2.com", "308", "http://loop1.com"),
+		page(day+60, "http://long1.com", "301", "http://long2.com"),
+		page(day+70, "http://long2.com", "301", "http://long3.com"),
+		page(day+80, "http://long3.com", "301", "http://long4.com"),
+		// Captured on the next day, not part of the chain of a.com
+		page(day+SECONDS_PER_DAY, "http://c.com", "301", "http://d.com"),
+	})
+
+	outputParquet := path.Join(dir, "redirects.parquet")
+	rows, err := ResolveRedirects([]string{path.Join(dir, "first.parquet"), path.Join(dir, "second.parquet")}, outputParquet, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []RedirectResolution{
+		{Date: day, DataOrigin: "test", Source: "http://a.com", Final: "http://c.com", Hops: 2, Codes: "301,302", Status: REDIRECT_RESOLVED},
+		{Date: day, DataOrigin: "test", Source: "http://b.com", Final: "http://c.com", Hops: 1, Codes: "302", Status: REDIRECT_RESOLVED},
+		{Date: day, DataOrigin: "test", Source: "http://long1.com", Final: "http://long3.com", Hops: 2, Codes: "301,301", Status: REDIRECT_TOO_LONG},
+		{Date: day, DataOrigin: "test", Source: "http://long2.com", Final: "http://long4.com", Hops: 2, Codes: "301,301", Status: REDIRECT_RESOLVED},
+		{Date: day, DataOrigin: "test", Source: "http://long3.com", Final: "http://long4.com", Hops: 1, Codes: "301", Status: REDIRECT_RESOLVED},
+		{Date: day, DataOrigin: "test", Source: "http://loop1.com", Final: "http://loop1.com", Hops: 2, Codes: "307,308", Status: REDIRECT_LOOP},
+		{Date: day, DataOrigin: "test", Source: "http://loop2.com", Final: "http://loop2.com", Hops: 2, Codes: "308,307", Status: REDIRECT_LOOP},
+		{Date: day, DataOrigin: "test", Source: "http://old.com", Final: "http://upgrade.com", Hops: 2, Codes: "301,301", Status: REDIRECT_RESOLVED},
+		{Date: day, DataOrigin: "test", Source: "http://upgrade.com", Final: "http://upgrade.com", Hops: 1, Codes: "301", Status: REDIRECT_RESOLVED},
+		{Date: day + SECONDS_PER_DAY, DataOrigin: "test", Source: "http://c.com", Final: "http://d.com", Hops: 1, Codes: "301", Status: REDIRECT_RESOLVED},
+	}
+	if rows != len(expected) {
+		t.Fatalf("expected %d rows, got %d", len(expected), rows)
+	}
+
+	fr, err := local.NewLocalFileReader(outputParquet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fr.Close()
+	pr, err := reader.NewParquetReader(fr, new(RedirectResolution), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolutions := make([]RedirectResolution, pr.GetNumRows())
+	if err := pr.Read(&resolutions); err != nil {
+		t.Fatal(err)
+	}
+	pr.ReadStop()
+
+	for i, resolution := range resolutions {
+		if resolution != expected[i] {
+			t.Errorf("row %d: got %+v, expected %+v", i, resolution, expected[i])
+		}
+	}
+}
+
+func TestResolveRedirectsParts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-redirects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	day := int64(1564185600) // 2019-07-27
+	markers := []Marker{
+		NewWebpageMarker(day+10, "com.a", false, "http://a.com", "301", "http://b.com", "test"),
+		NewWebpageMarker(day+20, "org.b", false, "http://b.org", "302", "http://c.org", "test"),
+		NewWebpageMarker(day+30, "com.c", false, "http://c.com", "301", "http://d.com", "test"),
+	}
+
+	// Rolled output, one part per marker
+	for _, subdir := range []string{"rolled", "empty"} {
+		if err := os.MkdirAll(path.Join(dir, subdir), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	options := DefaultParquetOptions()
+	options.MaxPartRows = 1
+	rolled, err := NewSink(path.Join(dir, "rolled", "out.parquet"), "test.warc", FORMAT_PARQUET, COMPRESSION_NONE, options, DefaultHttpOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Partitioned output, by top-level domain
+	options = DefaultParquetOptions()
+	options.Partitioned = true
+	partitioned, err := NewSink(path.Join(dir, "partitioned"), "test.warc", FORMAT_PARQUET, COMPRESSION_NONE, options, DefaultHttpOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sink := range []Sink{rolled, partitioned} {
+		for _, marker := range markers {
+			if err := sink.Write(marker); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, input := range []string{
+		path.Join(dir, "rolled", "out.parquet"),
+		path.Join(dir, "rolled", "out.manifest.json"),
+		path.Join(dir, "rolled"),
+		path.Join(dir, "partitioned", "_test.warc.manifest.json"),
+		path.Join(dir, "partitioned"),
+	} {
+		rows, err := ResolveRedirects([]string{input}, path.Join(dir, "redirects.parquet"), 2)
+		if err != nil {
+			t.Errorf("%s: %s", input, err)
+		} else if rows != len(markers) {
+			t.Errorf("%s: expected %d redirects, got %d", input, len(markers), rows)
+		}
+	}
+
+	for _, input := range []string{"s3://bucket/out.parquet", path.Join(dir, "missing.parquet"), path.Join(dir, "empty")} {
+		if _, err := ResolveRedirects([]string{input}, path.Join(dir, "redirects.parquet"), 2); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
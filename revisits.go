@@ -0,0 +1,174 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/purell"
+	"net/url"
+	"time"
+)
+
+// Fraction of the share of the memory budget of an extraction the links of the original
+// captures can take, the oldest captures are forgotten to keep the new ones
+const ORIGINAL_CAPTURES_BUDGET_SHARE = 8
+
+// Links and canonical extracted from an HTML response, kept to be copied in the revisits of the page
+type originalCapture struct {
+	recordId      string
+	uri           string
+	date          int64
+	payloadDigest string
+	links         *MarkerBatch
+	canonical     string
+	// Bytes taken from the memory budget
+	size int64
+}
+
+type captureUriDate struct {
+	uri  string
+	date int64
+}
+
+type captureDigestUri struct {
+	payloadDigest string
+	uri           string
+}
+
+// Original captures of the WARC, found by the record ID, or by the URL and the date, a
+// revisit refers to. The links are kept in batches, which store the source once, and
+// held in the memory budget up to a share of it.
+type originalCaptures struct {
+	byRecordId  map[string]*originalCapture
+	byUriDate   map[captureUriDate]*originalCapture
+	byDigestUri map[captureDigestUri]*originalCapture
+	// Captures in the order they were added
+	captures []*originalCapture
+	budget   *MemoryBudget
+	size     int64
+	maxSize  int64
+}
+
+func newOriginalCaptures(budget *MemoryBudget) *originalCaptures {
+	return &originalCaptures{
+		byRecordId:  map[string]*originalCapture{},
+		byUriDate:   map[captureUriDate]*originalCapture{},
+		byDigestUri: map[captureDigestUri]*originalCapture{},
+		budget:      budget,
+		maxSize:     budget.share(ORIGINAL_CAPTURES_BUDGET_SHARE),
+	}
+}
+
+// Keeps the links of a response whose payload may be referred by later revisit records
+func (o *originalCaptures) add(page *Marker, pageLinks *MarkerBatch, canonical string) {
+	if len(page.WarcPayloadDigest) == 0 {
+		return
+	}
+	capture := &originalCapture{
+		recordId:      page.WarcRecordId,
+		uri:           page.Source,
+		date:          page.Date,
+		payloadDigest: page.WarcPayloadDigest,
+		links:         NewMarkerBatch(),
+		canonical:     canonical,
+	}
+	capture.links.AppendBatch(pageLinks)
+	capture.size = capture.links.size + int64(len(capture.recordId)+len(capture.uri)+len(capture.payloadDigest)+len(canonical))
+
+	if len(capture.recordId) > 0 {
+		o.byRecordId[capture.recordId] = capture
+	}
+	o.byUriDate[captureUriDate{capture.uri, capture.date}] = capture
+	o.byDigestUri[captureDigestUri{capture.payloadDigest, capture.uri}] = capture
+	o.captures = append(o.captures, capture)
+	o.size += capture.size
+	o.budget.add(capture.size)
+	o.budget.hold(capture.size)
+
+	for o.size > o.maxSize && len(o.captures) > 0 {
+		o.remove(o.captures[0])
+		o.captures = o.captures[1:]
+	}
+}
+
+// Forgets the capture and gives its bytes back to the budget
+func (o *originalCaptures) remove(capture *originalCapture) {
+	if o.byRecordId[capture.recordId] == capture {
+		delete(o.byRecordId, capture.recordId)
+	}
+	if key := (captureUriDate{capture.uri, capture.date}); o.byUriDate[key] == capture {
+		delete(o.byUriDate, key)
+	}
+	if key := (captureDigestUri{capture.payloadDigest, capture.uri}); o.byDigestUri[key] == capture {
+		delete(o.byDigestUri, key)
+	}
+	o.size -= capture.size
+	o.budget.unhold(capture.size)
+	o.budget.release(capture.size)
+	capture.links.Release()
+}
+
+// Forgets all the captures
+func (o *originalCaptures) clear() {
+	for _, capture := range o.captures {
+		o.remove(capture)
+	}
+	o.captures = nil
+}
+
+// Original capture of a revisit with the same payload: the one with the record ID of
+// WARC-Refers-To, or else the one of WARC-Refers-To-Target-URI and WARC-Refers-To-Date.
+// Without them, the latest capture of the URL the revisit refers to, or of its own URL.
+func (o *originalCaptures) find(revisit *Marker, refersToId string) *originalCapture {
+	var capture *originalCapture
+	if len(refersToId) > 0 {
+		capture = o.byRecordId[refersToId]
+	}
+	if capture == nil && len(revisit.RefersToUri) > 0 && revisit.RefersToDate != 0 {
+		capture = o.byUriDate[captureUriDate{revisit.RefersToUri, revisit.RefersToDate}]
+	}
+	if capture == nil && len(refersToId) == 0 && revisit.RefersToDate == 0 {
+		uri := revisit.RefersToUri
+		if len(uri) == 0 {
+			uri = revisit.Source
+		}
+		capture = o.byDigestUri[captureDigestUri{revisit.WarcPayloadDigest, uri}]
+	}
+	if capture == nil || len(revisit.WarcPayloadDigest) == 0 || capture.payloadDigest != revisit.WarcPayloadDigest {
+		return nil
+	}
+	return capture
+}
+
+// Appends to the batch the links of the original capture of the revisit, as if they were
+// extracted from the revisited page. It returns the canonical of the original, nothing
+// is copied if the original capture is not in the WARC.
+func (o *originalCaptures) copyLinks(revisit *Marker, refersToId string, markersBuffer *MarkerBatch) string {
+	capture := o.find(revisit, refersToId)
+	if capture == nil {
+		return ""
+	}
+	var link Marker
+	for i := 0; i < capture.links.Len(); i++ {
+		capture.links.Row(i, &link)
+		link.Date = revisit.Date
+		link.SourceHost = revisit.SourceHost
+		link.Source = revisit.Source
+		link.WarcFile = revisit.WarcFile
+		link.WarcOffset = revisit.WarcOffset
+		markersBuffer.Append(&link)
+	}
+	return capture.canonical
+}
+
+// Returns the normalized URL and the date of the capture a revisit record refers to.
+// The date is 0 if the record does not tell it.
+func getRevisitReference(header WarcHeader) (string, int64) {
+	refersTo := sanitizeString(header.Get("WARC-Refers-To-Target-URI"))
+	if refersToUrl, err := url.Parse(refersTo); err == nil && len(refersTo) > 0 {
+		refersTo = purell.NormalizeURL(refersToUrl, PURELL_FLAGS)
+	}
+
+	var refersToDate int64
+	if date, err := time.Parse(time.RFC3339, header.Get("WARC-Refers-To-Date")); err == nil {
+		refersToDate = date.Unix()
+	}
+	return toValidUTF8(refersTo), refersToDate
+}
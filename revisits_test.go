@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOriginalCaptures(t *testing.T) {
+	digest := "sha1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	budget := NewMemoryBudget(1 * MB)
+	originals := newOriginalCaptures(budget)
+
+	// Two pages with the same payload, e.g. an empty page
+	first := NewWebpageMarker(100, "com.a", false, "http://a.com", "200", "", "test")
+	first.WarcRecordId = "<urn:uuid:a>"
+	first.WarcPayloadDigest = digest
+	second := NewWebpageMarker(200, "com.b", false, "http://b.com", "200", "", "test")
+	second.WarcRecordId = "<urn:uuid:b>"
+	second.WarcPayloadDigest = digest
+	for _, page := range []*Marker{&first, &second} {
+		links := NewMarkerBatch()
+		link := NewMarker(page.Date, page.SourceHost, false, page.Source, page.Source+"/link", "", "a", "", "test")
+		links.Append(&link)
+		originals.add(page, links, page.Source+"/home")
+		links.Release()
+	}
+	if budget.Used() != originals.size || originals.size == 0 {
+		t.Errorf("expected the captures to take %d bytes from the budget, got %d", originals.size, budget.Used())
+	}
+
+	revisit := func(source, refersToUri string, refersToDate int64, payloadDigest string) *Marker {
+		page := NewWebpageMarker(300, "com.c", false, source, "200", "", "test")
+		page.Revisit = true
+		page.RefersToUri = refersToUri
+		page.RefersToDate = refersToDate
+		page.WarcPayloadDigest = payloadDigest
+		return &page
+	}
+	for _, test := range []struct {
+		name       string
+		revisit    *Marker
+		refersToId string
+		canonical  string
+	}{
+		{"record ID", revisit("http://a.com", "", 0, digest), "<urn:uuid:a>", "http://a.com/home"},
+		{"URI and date", revisit("http://www.a.com", "http://a.com", 100, digest), "", "http://a.com/home"},
+		{"own URI", revisit("http://b.com", "", 0, digest), "", "http://b.com/home"},
+		{"referred URI", revisit("http://www.b.com", "http://b.com", 0, digest), "", "http://b.com/home"},
+		// Another capture of the payload is not the original of the revisit
+		{"other URI", revisit("http://c.com", "", 0, digest), "", ""},
+		{"other date", revisit("http://a.com", "http://a.com", 150, digest), "", ""},
+		{"other record", revisit("http://a.com", "", 0, digest), "<urn:uuid:c>", ""},
+		{"other digest", revisit("http://a.com", "", 0, "sha1:B"), "<urn:uuid:a>", ""},
+	} {
+		batch := NewMarkerBatch()
+		canonical := originals.copyLinks(test.revisit, test.refersToId, batch)
+		if canonical != test.canonical {
+			t.Errorf("%s: got the canonical %q, expected %q", test.name, canonical, test.canonical)
+		}
+		if copied := batchMarkers(batch); len(test.canonical) > 0 && (len(copied) != 1 ||
+			copied[0].Link != test.canonical[:len(test.canonical)-len("/home")]+"/link" || copied[0].Source != test.revisit.Source) {
+			t.Errorf("%s: unexpected links %+v", test.name, copied)
+		}
+		batch.Release()
+	}
+
+	originals.clear()
+	if budget.Used() != 0 || originals.size != 0 || len(originals.byRecordId) != 0 {
+		t.Errorf("the captures were not cleared, %d bytes left in the budget", budget.Used())
+	}
+}
+
+func TestOriginalCapturesLimit(t *testing.T) {
+	budget := NewMemoryBudget(ORIGINAL_CAPTURES_BUDGET_SHARE * 100 * markerRowSize)
+	originals := newOriginalCaptures(budget)
+
+	pages := make([]Marker, 20)
+	for i := range pages {
+		pages[i] = NewWebpageMarker(int64(i), "com.example", false, "http://example.com/"+string(rune('a'+i)), "200", "", "test")
+		pages[i].WarcPayloadDigest = "sha1:" + string(rune('A'+i))
+		links := NewMarkerBatch()
+		for _, link := range testPageLinks(i, 10) {
+			links.Append(&link)
+		}
+		originals.add(&pages[i], links, "")
+		links.Release()
+
+		if originals.size > originals.maxSize || budget.Used() != originals.size {
+			t.Fatalf("%d captures take %d bytes, %d from the budget, the limit is %d", i+1, originals.size, budget.Used(), originals.maxSize)
+		}
+	}
+
+	// The oldest captures were forgotten
+	batch := NewMarkerBatch()
+	defer batch.Release()
+	if originals.copyLinks(&pages[0], "", batch); batch.Len() != 0 {
+		t.Errorf("expected the first capture to be forgotten")
+	}
+	last := pages[len(pages)-1]
+	if originals.copyLinks(&last, "", batch); batch.Len() != 10 {
+		t.Errorf("expected the links of the last capture, got %d", batch.Len())
+	}
+}
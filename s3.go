@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/xitongsys/parquet-go/source"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Size of the parts of the multipart uploads, S3 requires at least 5 MB but for the last one
+const DEFAULT_S3_PART_SIZE = 8 * MB
+
+const DEFAULT_S3_REGION = "us-east-1"
+
+// SHA-256 of an empty payload, as signed for the requests without a body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Checks if the path is an s3://bucket/key URL
+func isS3Url(p string) bool {
+	return strings.HasPrefix(p, "s3://")
+}
+
+// Splits an s3://bucket/key URL in its bucket and key
+func parseS3Url(s3Url string) (string, string, error) {
+	bucketKey := strings.TrimPrefix(s3Url, "s3://")
+	slash := strings.IndexByte(bucketKey, '/')
+	if slash < 1 || slash == len(bucketKey)-1 {
+		return "", "", fmt.Errorf("invalid S3 URL %q, expected s3://bucket/key", s3Url)
+	}
+	return bucketKey[:slash], bucketKey[slash+1:], nil
+}
+
+// Joins the elements to the output path like path.Join, keeping the scheme of the S3 URLs
+func joinOutputPath(root string, elem ...string) string {
+	if isS3Url(root) {
+		return "s3://" + path.Join(append([]string{strings.TrimPrefix(root, "s3://")}, elem...)...)
+	}
+	return path.Join(append([]string{root}, elem...)...)
+}
+
+// Endpoint, region and credentials of an S3-compatible store, such as MinIO. The objects
+// are addressed in path style, <endpoint>/<bucket>/<key>, which any store supports.
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	PartSize        int64
+	Retries         int
+	Backoff         time.Duration
+	Client          *http.Client
+}
+
+// Loads the configuration like the AWS tools: from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN, AWS_REGION and AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL) variables, then
+// from the AWS_PROFILE profile of ~/.aws/credentials and ~/.aws/config, whose paths can be changed
+// with AWS_SHARED_CREDENTIALS_FILE and AWS_CONFIG_FILE. The requests are anonymous without credentials.
+// The failed requests are retried as the ones of the HTTP(S) inputs.
+func loadS3Config(httpOptions HttpOptions) (S3Config, error) {
+	config := S3Config{
+		AccessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Region:          firstNonEmpty(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")),
+		Endpoint:        firstNonEmpty(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")),
+		PartSize:        DEFAULT_S3_PART_SIZE,
+		Retries:         httpOptions.Retries,
+		Backoff:         httpOptions.Backoff,
+		Client:          http.DefaultClient,
+	}
+	if httpOptions.Client != nil {
+		config.Client = httpOptions.Client
+	}
+
+	profile := firstNonEmpty(os.Getenv("AWS_PROFILE"), "default")
+	home, _ := os.UserHomeDir()
+	if len(config.AccessKeyId) == 0 {
+		credentialsFile := firstNonEmpty(os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), path.Join(home, ".aws", "credentials"))
+		credentials, err := readIniSection(credentialsFile, profile)
+		if err != nil {
+			return config, err
+		}
+		config.AccessKeyId = credentials["aws_access_key_id"]
+		config.SecretAccessKey = credentials["aws_secret_access_key"]
+		config.SessionToken = credentials["aws_session_token"]
+	}
+	if len(config.Region) == 0 || len(config.Endpoint) == 0 {
+		// The profiles other than the default one are named "profile <name>" in the config file
+		section := profile
+		if profile != "default" {
+			section = "profile " + profile
+		}
+		configFile := firstNonEmpty(os.Getenv("AWS_CONFIG_FILE"), path.Join(home, ".aws", "config"))
+		values, err := readIniSection(configFile, section)
+		if err != nil {
+			return config, err
+		}
+		config.Region = firstNonEmpty(config.Region, values["region"])
+		config.Endpoint = firstNonEmpty(config.Endpoint, values["endpoint_url"])
+	}
+
+	config.Region = firstNonEmpty(config.Region, DEFAULT_S3_REGION)
+	config.Endpoint = strings.TrimRight(firstNonEmpty(config.Endpoint, "https://s3."+config.Region+".amazonaws.com"), "/")
+	if len(config.AccessKeyId) == 0 != (len(config.SecretAccessKey) == 0) {
+		return config, errors.New("incomplete S3 credentials, both the access key id and the secret access key are required")
+	}
+	return config, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// Reads the keys of a section of an AWS configuration file, it is empty if the file does not exist
+func readIniSection(filePath, section string) (map[string]string, error) {
+	values := map[string]string{}
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return values, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	current := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if equal := strings.IndexByte(line, '='); equal > 0 && current == section {
+			values[strings.ToLower(strings.TrimSpace(line[:equal]))] = strings.TrimSpace(line[equal+1:])
+		}
+	}
+	return values, scanner.Err()
+}
+
+// URL of the object, query is the encoded query string or empty
+func (c S3Config) objectUrl(bucket, key, query string) string {
+	objectUrl := c.Endpoint + "/" + s3Escape(bucket, false) + "/" + s3Escape(key, true)
+	if len(query) > 0 {
+		objectUrl += "?" + query
+	}
+	return objectUrl
+}
+
+// Percent-encodes all the characters but the unreserved ones, and the slashes if keepSlash
+func s3Escape(value string, keepSlash bool) string {
+	const hex = "0123456789ABCDEF"
+	var escaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && keepSlash {
+			escaped.WriteByte(c)
+		} else {
+			escaped.WriteByte('%')
+			escaped.WriteByte(hex[c>>4])
+			escaped.WriteByte(hex[c&0xF])
+		}
+	}
+	return escaped.String()
+}
+
+// Signs the request with AWS Signature Version 4, payloadHash is the hex SHA-256 of the body.
+// The host, the Range and the x-amz-* headers are signed. Without credentials the request is
+// left anonymous.
+func (c S3Config) sign(request *http.Request, payloadHash string, now time.Time) {
+	if len(c.AccessKeyId) == 0 {
+		return
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if len(c.SessionToken) > 0 {
+		request.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		canonicalQuery(request.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSha256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSha256(key, c.Region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.AccessKeyId+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// Query string sorted by key, with the keys and values encoded
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parameters []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parameters = append(parameters, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(parameters, "&")
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Error returned by the store, with the code of its XML body
+type s3Error struct {
+	Status  string
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if len(e.Code) > 0 {
+		return fmt.Sprintf("S3 %s: %s %s", e.Status, e.Code, e.Message)
+	}
+	return "S3 " + e.Status
+}
+
+func newS3Error(response *http.Response) *s3Error {
+	s3Err := &s3Error{Status: response.Status}
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 64*1024))
+	xml.Unmarshal(body, s3Err)
+	return s3Err
+}
+
+// Sends a signed request with the body, retrying with exponential back-off the network
+// errors and the server errors. The response is returned when its status is 2xx.
+func (c S3Config) do(method, bucket, key, query string, body []byte) (*http.Response, error) {
+	var err error
+	wait := c.Backoff
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(wait)
+			if wait *= 2; wait > MAX_HTTP_BACKOFF {
+				wait = MAX_HTTP_BACKOFF
+			}
+		}
+		var request *http.Request
+		request, err = http.NewRequest(method, c.objectUrl(bucket, key, query), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		c.sign(request, sha256Hex(body), time.Now())
+
+		var response *http.Response
+		response, err = c.Client.Do(request)
+		if err != nil {
+			continue
+		}
+		if response.StatusCode/100 == 2 {
+			return response, nil
+		}
+		err = newS3Error(response)
+		response.Body.Close()
+		if response.StatusCode < 500 && response.StatusCode != http.StatusTooManyRequests {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// Streams the object with the ranged requests of the HTTP inputs, resumed when the connection drops
+func openS3Input(s3Url string, options HttpOptions) (io.ReadCloser, error) {
+	bucket, key, err := parseS3Url(s3Url)
+	if err != nil {
+		return nil, err
+	}
+	config, err := loadS3Config(options)
+	if err != nil {
+		return nil, err
+	}
+	options.Client = config.Client
+	return openHttpInput(config.objectUrl(bucket, key, ""), options, func(request *http.Request) {
+		config.sign(request, emptyPayloadHash, time.Now())
+	})
+}
+
+// Object written with a multipart upload, the parts are uploaded as soon as they are
+// buffered. An object smaller than a part is uploaded with a single request by Close.
+type s3Upload struct {
+	config   S3Config
+	bucket   string
+	key      string
+	buffer   bytes.Buffer
+	uploadId string
+	etags    []string
+	err      error
+	closed   bool
+}
+
+// Creates the object at the s3://bucket/key URL, it is visible once closed
+func createS3Object(s3Url string, httpOptions HttpOptions) (*s3Upload, error) {
+	bucket, key, err := parseS3Url(s3Url)
+	if err != nil {
+		return nil, err
+	}
+	config, err := loadS3Config(httpOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Upload{config: config, bucket: bucket, key: key}, nil
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	if u.closed {
+		return 0, errors.New("object already closed")
+	}
+	if u.err != nil {
+		return 0, u.err
+	}
+	u.buffer.Write(p)
+	for int64(u.buffer.Len()) >= u.config.PartSize {
+		if u.err = u.uploadPart(u.buffer.Next(int(u.config.PartSize))); u.err != nil {
+			return len(p), u.err
+		}
+	}
+	return len(p), nil
+}
+
+// Uploads the next part, starting the multipart upload with the first one
+func (u *s3Upload) uploadPart(part []byte) error {
+	if len(u.uploadId) == 0 {
+		response, err := u.config.do(http.MethodPost, u.bucket, u.key, "uploads", nil)
+		if err != nil {
+			return err
+		}
+		var initiated struct {
+			UploadId string `xml:"UploadId"`
+		}
+		err = xml.NewDecoder(response.Body).Decode(&initiated)
+		response.Body.Close()
+		if err != nil || len(initiated.UploadId) == 0 {
+			return fmt.Errorf("invalid response to the creation of the multipart upload: %v", err)
+		}
+		u.uploadId = initiated.UploadId
+	}
+
+	query := "partNumber=" + strconv.Itoa(len(u.etags)+1) + "&uploadId=" + url.QueryEscape(u.uploadId)
+	response, err := u.config.do(http.MethodPut, u.bucket, u.key, query, part)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	u.etags = append(u.etags, response.Header.Get("ETag"))
+	return nil
+}
+
+// Uploads what is left and completes the upload, or aborts it if a part failed
+func (u *s3Upload) Close() error {
+	if u.closed {
+		return u.err
+	}
+	u.closed = true
+	if u.err != nil {
+		u.abort()
+		return u.err
+	}
+	if len(u.uploadId) == 0 {
+		response, err := u.config.do(http.MethodPut, u.bucket, u.key, "", u.buffer.Bytes())
+		if err != nil {
+			u.err = err
+			return err
+		}
+		response.Body.Close()
+		return nil
+	}
+
+	if u.buffer.Len() > 0 {
+		if u.err = u.uploadPart(u.buffer.Bytes()); u.err != nil {
+			u.abort()
+			return u.err
+		}
+	}
+	var complete bytes.Buffer
+	complete.WriteString("<CompleteMultipartUpload>")
+	for i, etag := range u.etags {
+		fmt.Fprintf(&complete, "<Part><PartNumber>%d</PartNumber><ETag>", i+1)
+		xml.EscapeText(&complete, []byte(etag))
+		complete.WriteString("</ETag></Part>")
+	}
+	complete.WriteString("</CompleteMultipartUpload>")
+
+	response, err := u.config.do(http.MethodPost, u.bucket, u.key, "uploadId="+url.QueryEscape(u.uploadId), complete.Bytes())
+	if err == nil {
+		// The completion can fail after the status is sent, the error is then in the body
+		body, readErr := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if readErr != nil {
+			err = readErr
+		} else if bytes.Contains(body, []byte("<Error>")) {
+			s3Err := &s3Error{Status: response.Status}
+			xml.Unmarshal(body, s3Err)
+			err = s3Err
+		}
+	}
+	if err != nil {
+		u.err = err
+		u.abort()
+		return err
+	}
+	return nil
+}
+
+// Discards the parts uploaded, the store keeps them until the upload is aborted
+func (u *s3Upload) abort() {
+	if len(u.uploadId) == 0 {
+		return
+	}
+	if response, err := u.config.do(http.MethodDelete, u.bucket, u.key, "uploadId="+url.QueryEscape(u.uploadId), nil); err == nil {
+		response.Body.Close()
+	}
+	u.uploadId = ""
+}
+
+// Creates the file of an output, an object uploaded to S3 for the s3:// URLs
+func createDestination(destination string, httpOptions HttpOptions) (io.WriteCloser, error) {
+	if isS3Url(destination) {
+		return createS3Object(destination, httpOptions)
+	}
+	return os.Create(destination)
+}
+
+// Writes the content in the file of an output, or in the object for the s3:// URLs
+func writeDestination(destination string, content []byte, httpOptions HttpOptions) error {
+	if isS3Url(destination) {
+		object, err := createS3Object(destination, httpOptions)
+		if err != nil {
+			return err
+		}
+		object.Write(content)
+		return object.Close()
+	}
+	return ioutil.WriteFile(destination, content, 0644)
+}
+
+// Creates the directory of an output, the S3 keys need none
+func makeDestinationDir(dir string) error {
+	if isS3Url(dir) {
+		return nil
+	}
+	return os.MkdirAll(dir, os.ModePerm)
+}
+
+// Parquet file uploaded to S3, only written
+type s3ParquetFile struct {
+	*s3Upload
+	httpOptions HttpOptions
+}
+
+func (f *s3ParquetFile) Create(name string) (source.ParquetFile, error) {
+	object, err := createS3Object(name, f.httpOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &s3ParquetFile{object, f.httpOptions}, nil
+}
+
+func (f *s3ParquetFile) Open(name string) (source.ParquetFile, error) {
+	return nil, errors.New("the S3 Parquet files can only be written")
+}
+
+func (f *s3ParquetFile) Read(p []byte) (int, error) {
+	return 0, errors.New("the S3 Parquet files can only be written")
+}
+
+func (f *s3ParquetFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("the S3 Parquet files can only be written")
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Formats of the output
+const (
+	FORMAT_PARQUET = "parquet"
+	FORMAT_JSONL   = "jsonl"
+	FORMAT_CSV     = "csv"
+	FORMAT_TSV     = "tsv"
+	FORMAT_ARROW   = "arrow"
+)
+
+// Compressions of the text formats
+const (
+	COMPRESSION_NONE = "none"
+	COMPRESSION_GZIP = "gzip"
+	COMPRESSION_ZSTD = "zstd"
+)
+
+// Output path meaning the standard output
+const STDOUT_PATH = "-"
+
+// Checks if the markers are written one per line in the format, so that the output
+// can be streamed to the standard output and processed by line-oriented tools
+func isLineFormat(format string) bool {
+	return format == FORMAT_JSONL || format == FORMAT_CSV || format == FORMAT_TSV
+}
+
+// Destination of the markers extracted from a WARC
+type Sink interface {
+	Write(marker Marker) error
+	// Writes the markers of the batch, the batch is not kept
+	WriteBatch(batch *MarkerBatch) error
+	// Finalizes the output, it must be called after a failure as well
+	Close() error
+	Stats() OutputStats
+}
+
+// Checks the format and compression of the output, the Parquet outputs can be
+// split in parts or partitioned, the text ones can be compressed.
+func validateSinkFormat(format, compression string, parquetOptions ParquetOptions) error {
+	switch format {
+	case FORMAT_PARQUET, FORMAT_ARROW:
+		if compression != COMPRESSION_NONE {
+			return fmt.Errorf("the %s output cannot be compressed", format)
+		}
+	case FORMAT_JSONL, FORMAT_CSV, FORMAT_TSV:
+		if compression != COMPRESSION_NONE && compression != COMPRESSION_GZIP && compression != COMPRESSION_ZSTD {
+			return fmt.Errorf("unknown compression %q, expected one of none, gzip, zstd", compression)
+		}
+	default:
+		return fmt.Errorf("unknown format %q, expected one of parquet, jsonl, csv, tsv, arrow", format)
+	}
+	if format != FORMAT_PARQUET && (parquetOptions.rolling() || parquetOptions.Partitioned) {
+		return fmt.Errorf("only the parquet output can be split in parts or partitioned")
+	}
+	return nil
+}
+
+// Extension of the files of the format, e.g. ".parquet" or ".csv.gz"
+func outputExtension(format, compression string) string {
+	switch compression {
+	case COMPRESSION_GZIP:
+		return "." + format + ".gz"
+	case COMPRESSION_ZSTD:
+		return "." + format + ".zst"
+	}
+	return "." + format
+}
+
+// Creates the output of the markers of a WARC in the format, destination is the root
+// directory of a partitioned output or STDOUT_PATH for the line formats. The S3 requests are
+// retried as set in httpOptions. The errors are *WriterError.
+func NewSink(destination, warcFile, format, compression string, parquetOptions ParquetOptions, httpOptions HttpOptions) (Sink, error) {
+	if destination == STDOUT_PATH && !isLineFormat(format) {
+		return nil, &WriterError{Destination: destination, Op: "create",
+			Err: fmt.Errorf("the %s output cannot be written to the standard output", format)}
+	}
+	switch format {
+	case FORMAT_JSONL:
+		return newJsonlSink(destination, compression, httpOptions)
+	case FORMAT_CSV:
+		return newCsvSink(destination, compression, ',', httpOptions)
+	case FORMAT_TSV:
+		return newCsvSink(destination, compression, '\t', httpOptions)
+	case FORMAT_ARROW:
+		return newArrowSink(destination, httpOptions)
+	}
+	if parquetOptions.Partitioned {
+		return NewPartitionedOutput(destination, inputName(warcFile), parquetOptions, httpOptions), nil
+	}
+	return NewParquetOutput(destination, parquetOptions, httpOptions)
+}
+
+// Column of the text and Arrow outputs, named as in the Parquet schema. batchField is
+// the column of the same name in a MarkerBatch, -1 if there is none.
+type markerColumn struct {
+	name       string
+	field      int
+	batchField int
+	kind       reflect.Kind
+}
+
+var markerColumns = columnsOf(reflect.TypeOf(Marker{}))
+
+// Columns of the fields of a struct with a parquet tag, in the order of the fields
+func columnsOf(structType reflect.Type) []markerColumn {
+	batchType := reflect.TypeOf(MarkerBatch{})
+	var columns []markerColumn
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		for _, option := range strings.Split(field.Tag.Get("parquet"), ",") {
+			option = strings.TrimSpace(option)
+			if strings.HasPrefix(option, "name=") {
+				column := markerColumn{name: option[len("name="):], field: i, batchField: -1, kind: field.Type.Kind()}
+				if batchField, found := batchType.FieldByName(field.Name); found {
+					column.batchField = batchField.Index[0]
+				}
+				columns = append(columns, column)
+			}
+		}
+	}
+	return columns
+}
+
+// Values of the columns of a row, in the order of markerColumns, read from a marker
+// or from a row of a batch without copying it
+type rowValues []reflect.Value
+
+func newRowValues() rowValues {
+	return make(rowValues, len(markerColumns))
+}
+
+func (r rowValues) setMarker(marker *Marker) {
+	value := reflect.ValueOf(marker).Elem()
+	for i, column := range markerColumns {
+		r[i] = value.Field(column.field)
+	}
+}
+
+// Slices of the columns of the batch, in the order of markerColumns
+func batchColumns(batch *MarkerBatch) []reflect.Value {
+	value := reflect.ValueOf(batch).Elem()
+	columns := make([]reflect.Value, len(markerColumns))
+	for i, column := range markerColumns {
+		columns[i] = value.Field(column.batchField)
+	}
+	return columns
+}
+
+func (r rowValues) setBatchRow(columns []reflect.Value, row int) {
+	for i, column := range columns {
+		r[i] = column.Index(row)
+	}
+}
+
+// Writer counting the bytes written
+type countingWriter struct {
+	io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// Buffered file of a text or Arrow output, compressed with gzip or zstd if required.
+// The standard output is used for STDOUT_PATH, it is flushed but not closed, and an
+// object is uploaded for the s3:// URLs.
+type outputFile struct {
+	*bufio.Writer
+	file       io.WriteCloser
+	counter    *countingWriter
+	compressor io.WriteCloser
+}
+
+func createOutputFile(destination, compression string, httpOptions HttpOptions) (*outputFile, error) {
+	var file io.WriteCloser = os.Stdout
+	if destination != STDOUT_PATH {
+		var err error
+		if file, err = createDestination(destination, httpOptions); err != nil {
+			return nil, &WriterError{Destination: destination, Op: "create", Err: err}
+		}
+	}
+	output := &outputFile{file: file, counter: &countingWriter{Writer: file}}
+	var writer io.Writer = output.counter
+	switch compression {
+	case COMPRESSION_GZIP:
+		output.compressor = gzip.NewWriter(output.counter)
+	case COMPRESSION_ZSTD:
+		compressor, err := zstd.NewWriter(output.counter)
+		if err != nil {
+			output.closeFile()
+			return nil, &WriterError{Destination: destination, Op: "create", Err: err}
+		}
+		output.compressor = compressor
+	}
+	if output.compressor != nil {
+		writer = output.compressor
+	}
+	output.Writer = bufio.NewWriterSize(writer, 64*1024)
+	return output, nil
+}
+
+// Flushes the buffer and the compressor and closes the file
+func (f *outputFile) Close() error {
+	err := f.Flush()
+	if f.compressor != nil {
+		if closeErr := f.compressor.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := f.closeFile(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (f *outputFile) closeFile() error {
+	if f.file == os.Stdout {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// Bytes written in the file, compressed
+func (f *outputFile) written() int64 {
+	return f.counter.written
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+var testSinkMarkers = []Marker{
+	{Date: 1564221600, SourceHost: "com.example", Source: "http://example.com/", Link: "http://example.com/a",
+		Tag: "a", Extras: "Tab\there, \"quoted\"\nand new line", DataOrigin: "test", WarcOffset: 42},
+	{Date: 1564221600, SourceHost: "com.example", Secure: true, Source: "http://example.com/", Tag: "200",
+		DataOrigin: "test", Revisit: true, WarcLength: -1},
+}
+
+// Writes the test markers in the sink and returns the path of the file
+func writeTestSink(t *testing.T, dir, format, compression string) string {
+	destination := path.Join(dir, "out"+outputExtension(format, compression))
+	sink, err := NewSink(destination, "test.warc", format, compression, DefaultParquetOptions(), DefaultHttpOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, marker := range testSinkMarkers {
+		if err := sink.Write(marker); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := sink.Stats(); stats.Rows != int64(len(testSinkMarkers)) || stats.Bytes != info.Size() {
+		t.Errorf("%s: unexpected stats %+v, the file has %d bytes", format, stats, info.Size())
+	}
+	return destination
+}
+
+// Opens the file decompressing it
+func openTestSink(t *testing.T, destination, compression string) io.Reader {
+	file, err := os.Open(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	switch compression {
+	case COMPRESSION_GZIP:
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reader
+	case COMPRESSION_ZSTD:
+		reader, err := zstd.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(reader.Close)
+		return reader
+	}
+	return file
+}
+
+func TestJsonlSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, compression := range []string{COMPRESSION_NONE, COMPRESSION_ZSTD} {
+		destination := writeTestSink(t, dir, FORMAT_JSONL, compression)
+		scanner := bufio.NewScanner(openTestSink(t, destination, compression))
+		var rows []map[string]interface{}
+		for scanner.Scan() {
+			var row map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Fatalf("%s: %s", scanner.Text(), err)
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) != 2 || len(rows[0]) != len(markerColumns) {
+			t.Fatalf("unexpected rows %v", rows)
+		}
+		if rows[0]["extras"] != testSinkMarkers[0].Extras || rows[0]["source_host"] != "com.example" ||
+			rows[0]["warc_offset"] != 42.0 || rows[1]["revisit"] != true || rows[1]["warc_length"] != -1.0 {
+			t.Errorf("unexpected rows %v", rows)
+		}
+	}
+}
+
+func TestCsvSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		format, compression string
+		separator           rune
+	}{
+		{FORMAT_CSV, COMPRESSION_NONE, ','},
+		{FORMAT_TSV, COMPRESSION_GZIP, '\t'},
+	} {
+		destination := writeTestSink(t, dir, test.format, test.compression)
+		reader := csv.NewReader(openTestSink(t, destination, test.compression))
+		reader.Comma = test.separator
+		records, err := reader.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 3 || records[0][0] != "date" || records[0][1] != "source_host" {
+			t.Fatalf("%s: unexpected records %v", test.format, records)
+		}
+		if records[1][0] != "1564221600" || records[1][7] != testSinkMarkers[0].Extras || records[2][2] != "true" {
+			t.Errorf("%s: unexpected records %v", test.format, records)
+		}
+	}
+}
+
+func TestArrowSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	destination := writeTestSink(t, dir, FORMAT_ARROW, COMPRESSION_NONE)
+	reader, err := ipc.NewReader(openTestSink(t, destination, COMPRESSION_NONE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Release()
+
+	if !reader.Schema().Equal(arrowMarkerSchema()) {
+		t.Errorf("unexpected schema %s", reader.Schema())
+	}
+	rows := 0
+	for reader.Next() {
+		record := reader.Record()
+		links := record.Column(4).(*array.String)
+		revisits := record.Column(20).(*array.Boolean)
+		for i := 0; i < int(record.NumRows()); i++ {
+			if links.Value(i) != testSinkMarkers[rows].Link || revisits.Value(i) != testSinkMarkers[rows].Revisit {
+				t.Errorf("unexpected row %d", rows)
+			}
+			rows++
+		}
+	}
+	if rows != len(testSinkMarkers) {
+		t.Errorf("expected %d rows, got %d", len(testSinkMarkers), rows)
+	}
+}
+
+func TestSinkWriteBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	batch := NewMarkerBatch()
+	defer batch.Release()
+	for i := range testSinkMarkers {
+		batch.Append(&testSinkMarkers[i])
+	}
+
+	// The batches are written as their rows one by one
+	for _, format := range []string{FORMAT_JSONL, FORMAT_CSV, FORMAT_TSV, FORMAT_ARROW} {
+		expected, err := ioutil.ReadFile(writeTestSink(t, dir, format, COMPRESSION_NONE))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		destination := path.Join(dir, "batch"+outputExtension(format, COMPRESSION_NONE))
+		sink, err := NewSink(destination, "test.warc", format, COMPRESSION_NONE, DefaultParquetOptions(), DefaultHttpOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.WriteBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if stats := sink.Stats(); stats.Rows != int64(batch.Len()) {
+			t.Errorf("%s: unexpected stats %+v", format, stats)
+		}
+		if content, err := ioutil.ReadFile(destination); err != nil || !bytes.Equal(content, expected) {
+			t.Errorf("%s: the batch was written differently: %v", format, err)
+		}
+	}
+}
+
+func TestValidateSinkFormat(t *testing.T) {
+	rolling := DefaultParquetOptions()
+	rolling.MaxPartRows = 10
+	for _, test := range []struct {
+		format, compression string
+		options             ParquetOptions
+		valid               bool
+	}{
+		{FORMAT_PARQUET, COMPRESSION_NONE, rolling, true},
+		{FORMAT_TSV, COMPRESSION_ZSTD, DefaultParquetOptions(), true},
+		{FORMAT_ARROW, COMPRESSION_NONE, DefaultParquetOptions(), true},
+		{FORMAT_PARQUET, COMPRESSION_GZIP, DefaultParquetOptions(), false},
+		{FORMAT_CSV, "bzip2", DefaultParquetOptions(), false},
+		{FORMAT_JSONL, COMPRESSION_NONE, rolling, false},
+		{"xml", COMPRESSION_NONE, DefaultParquetOptions(), false},
+	} {
+		if err := validateSinkFormat(test.format, test.compression, test.options); (err == nil) != test.valid {
+			t.Errorf("%s %s: unexpected validation %v", test.format, test.compression, err)
+		}
+	}
+}
+
+func TestStdoutSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stdout, err := os.Create(path.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	originalStdout := os.Stdout
+	os.Stdout = stdout
+	defer func() { os.Stdout = originalStdout }()
+
+	if _, err := NewSink(STDOUT_PATH, "test.warc", FORMAT_PARQUET, COMPRESSION_NONE, DefaultParquetOptions(), DefaultHttpOptions()); err == nil {
+		t.Errorf("expected the Parquet output to be refused on the standard output")
+	}
+
+	sink, err := NewSink(STDOUT_PATH, "test.warc", FORMAT_TSV, COMPRESSION_NONE, DefaultParquetOptions(), DefaultHttpOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, marker := range testSinkMarkers {
+		if err := sink.Write(marker); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The standard output is left open
+	if _, err := stdout.WriteString("end\n"); err != nil {
+		t.Errorf("the standard output was closed: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := sink.Stats(); stats.Rows != 2 || stats.Bytes != int64(len(content)-len("end\n")) {
+		t.Errorf("unexpected stats %+v for %d bytes", stats, len(content))
+	}
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.Comma = '\t'
+	reader.FieldsPerRecord = -1
+	if records, err := reader.ReadAll(); err != nil || len(records) != 4 {
+		t.Errorf("unexpected output %q: %v", content, err)
+	}
+}
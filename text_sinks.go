@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/csv"
+	"reflect"
+	"strconv"
+)
+
+// Markers written as JSON objects, one per line, with the names of the Parquet columns
+type jsonlSink struct {
+	destination string
+	out         *outputFile
+	values      rowValues
+	line        []byte
+	rows        int64
+	bytes       int64
+}
+
+func newJsonlSink(destination, compression string, httpOptions HttpOptions) (*jsonlSink, error) {
+	out, err := createOutputFile(destination, compression, httpOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlSink{destination: destination, out: out, values: newRowValues()}, nil
+}
+
+func (s *jsonlSink) Write(marker Marker) error {
+	s.values.setMarker(&marker)
+	return s.writeValues()
+}
+
+func (s *jsonlSink) WriteBatch(batch *MarkerBatch) error {
+	columns := batchColumns(batch)
+	for row := 0; row < batch.Len(); row++ {
+		s.values.setBatchRow(columns, row)
+		if err := s.writeValues(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the line of the row in values
+func (s *jsonlSink) writeValues() error {
+	s.line = append(s.line[:0], '{')
+	for i, column := range markerColumns {
+		if i > 0 {
+			s.line = append(s.line, ',')
+		}
+		s.line = appendJsonString(s.line, column.name)
+		s.line = append(s.line, ':')
+		field := s.values[i]
+		switch column.kind {
+		case reflect.Int64, reflect.Int32:
+			s.line = strconv.AppendInt(s.line, field.Int(), 10)
+		case reflect.Bool:
+			s.line = strconv.AppendBool(s.line, field.Bool())
+		default:
+			s.line = appendJsonString(s.line, field.String())
+		}
+	}
+	s.line = append(s.line, '}', '\n')
+	if _, err := s.out.Write(s.line); err != nil {
+		return &WriterError{Destination: s.destination, Op: "write", Err: err}
+	}
+	s.rows++
+	return nil
+}
+
+func (s *jsonlSink) Close() error {
+	err := s.out.Close()
+	s.bytes = s.out.written()
+	if err != nil {
+		return &WriterError{Destination: s.destination, Op: "finalize", Err: err}
+	}
+	return nil
+}
+
+func (s *jsonlSink) Stats() OutputStats {
+	return OutputStats{Rows: s.rows, Bytes: s.bytes}
+}
+
+// Appends the JSON string of a valid UTF-8 value
+func appendJsonString(buffer []byte, value string) []byte {
+	const hex = "0123456789abcdef"
+	buffer = append(buffer, '"')
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			buffer = append(buffer, '\\', c)
+		case c == '\n':
+			buffer = append(buffer, '\\', 'n')
+		case c == '\r':
+			buffer = append(buffer, '\\', 'r')
+		case c == '\t':
+			buffer = append(buffer, '\\', 't')
+		case c < 0x20:
+			buffer = append(buffer, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+		default:
+			buffer = append(buffer, c)
+		}
+	}
+	return append(buffer, '"')
+}
+
+// Markers written as CSV or TSV rows, after a header with the names of the Parquet columns
+type csvSink struct {
+	destination string
+	out         *outputFile
+	writer      *csv.Writer
+	values      rowValues
+	record      []string
+	rows        int64
+	bytes       int64
+}
+
+func newCsvSink(destination, compression string, separator rune, httpOptions HttpOptions) (*csvSink, error) {
+	out, err := createOutputFile(destination, compression, httpOptions)
+	if err != nil {
+		return nil, err
+	}
+	sink := &csvSink{destination: destination, out: out, writer: csv.NewWriter(out),
+		values: newRowValues(), record: make([]string, len(markerColumns))}
+	sink.writer.Comma = separator
+	for i, column := range markerColumns {
+		sink.record[i] = column.name
+	}
+	if err := sink.writer.Write(sink.record); err != nil {
+		out.Close()
+		return nil, &WriterError{Destination: destination, Op: "create", Err: err}
+	}
+	return sink, nil
+}
+
+func (s *csvSink) Write(marker Marker) error {
+	s.values.setMarker(&marker)
+	return s.writeValues()
+}
+
+func (s *csvSink) WriteBatch(batch *MarkerBatch) error {
+	columns := batchColumns(batch)
+	for row := 0; row < batch.Len(); row++ {
+		s.values.setBatchRow(columns, row)
+		if err := s.writeValues(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the record of the row in values
+func (s *csvSink) writeValues() error {
+	for i, column := range markerColumns {
+		field := s.values[i]
+		switch column.kind {
+		case reflect.Int64, reflect.Int32:
+			s.record[i] = strconv.FormatInt(field.Int(), 10)
+		case reflect.Bool:
+			s.record[i] = strconv.FormatBool(field.Bool())
+		default:
+			s.record[i] = field.String()
+		}
+	}
+	if err := s.writer.Write(s.record); err != nil {
+		return &WriterError{Destination: s.destination, Op: "write", Err: err}
+	}
+	s.rows++
+	return nil
+}
+
+func (s *csvSink) Close() error {
+	s.writer.Flush()
+	err := s.writer.Error()
+	if closeErr := s.out.Close(); err == nil {
+		err = closeErr
+	}
+	s.bytes = s.out.written()
+	if err != nil {
+		return &WriterError{Destination: s.destination, Op: "finalize", Err: err}
+	}
+	return nil
+}
+
+func (s *csvSink) Stats() OutputStats {
+	return OutputStats{Rows: s.rows, Bytes: s.bytes}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Magic of the skippable frame holding the dictionary of the .warc.zst format
+const zstdDictionaryMagic = 0x184d2a5d
+
+var errNotWarcVersion = errors.New("expected WARC version line")
+
+// Header of a WARC record. Keys are stored lower case.
+type WarcHeader map[string]string
+
+// Returns the value of the header field, the key is case insensitive
+func (h WarcHeader) Get(key string) string {
+	return h[strings.ToLower(key)]
+}
+
+// Sets the value of the header field, the key is case insensitive
+func (h WarcHeader) Set(key, value string) {
+	h[strings.ToLower(key)] = value
+}
+
+// A WARC record and its position in the input file
+type WarcRecord struct {
+	Header  WarcHeader
+	Content io.Reader
+	// Byte offset of the record in the input. For gzipped and zstd WARCs this is the
+	// offset of the gzip member or zstd frame the record starts in.
+	Offset int64
+}
+
+// Counts the bytes consumed from the underlying reader
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// Sequential reader of WARC records that can resynchronize after a malformed record.
+// Gzipped WARCs are read member by member, so that after a failure the reader can
+// jump to the next member, which in Common Crawl WARCs is the next record. The frames
+// of zstd WARCs, the .warc.zst format of the Internet Archive, are read the same way.
+// ARC v1 files are read too, converting their records to WARC records.
+type WarcReader struct {
+	source *countingReader
+	input  *bufio.Reader
+
+	// Nil if the WARC is not gzipped
+	gzip *gzip.Reader
+	// Nil if the WARC is not compressed with zstd
+	zstd  *zstd.Decoder
+	frame *zstdFrameReader
+	// The input is read member by member, or frame by frame
+	members bool
+
+	records      *bufio.Reader
+	memberOffset int64
+	// The input is an ARC file, its records are converted to WARC records
+	arc bool
+
+	last *WarcRecord
+	// Content left of the last record
+	content *io.LimitedReader
+	// Error found consuming the content of the last record in RecordLength
+	contentErr error
+}
+
+// Creates a reader detecting gzip and zstd compressed WARCs from the first bytes.
+// ARC files, plain or compressed, are detected from their file header record.
+func NewWarcReader(reader io.Reader) (*WarcReader, error) {
+	r := &WarcReader{source: &countingReader{reader: reader}}
+	r.input = bufio.NewReader(r.source)
+
+	magic, err := r.input.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(magic, zstdMagic) || isZstdSkippableFrame(magic) {
+		if err := r.startZstd(); err != nil {
+			return nil, err
+		}
+	} else if bytes.HasPrefix(magic, gzipMagic) {
+		r.gzip, err = gzip.NewReader(r.input)
+		if err != nil {
+			return nil, err
+		}
+		r.gzip.Multistream(false)
+		r.members = true
+		r.records = bufio.NewReader(r.gzip)
+	} else {
+		r.records = r.input
+	}
+
+	prefix, err := r.records.Peek(len(arcMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	r.arc = string(prefix) == arcMagic
+	return r, nil
+}
+
+// Position in the input of the next byte to be parsed. Only meaningful for
+// uncompressed WARCs.
+func (r *WarcReader) position() int64 {
+	return r.source.count - int64(r.input.Buffered())
+}
+
+// Moves to the next gzip member or zstd frame if the current one is exhausted
+func (r *WarcReader) nextMember() error {
+	if _, err := r.records.Peek(1); err != io.EOF {
+		return err
+	}
+	if r.zstd != nil {
+		if err := r.skipZstdSkippableFrames(); err != nil {
+			return err
+		}
+	}
+	r.memberOffset = r.position()
+	return r.resetMember()
+}
+
+// Starts decompressing the gzip member or zstd frame at the current position
+func (r *WarcReader) resetMember() error {
+	if r.zstd != nil {
+		if err := r.frame.reset(r.input); err != nil {
+			return err
+		}
+		if err := r.zstd.Reset(r.frame); err != nil {
+			return err
+		}
+		r.records.Reset(r.zstd)
+		return nil
+	}
+	if err := r.gzip.Reset(r.input); err != nil {
+		return err
+	}
+	r.gzip.Multistream(false)
+	r.records.Reset(r.gzip)
+	return nil
+}
+
+// Reads the next record. The content of the previous record is discarded.
+// It returns io.EOF at the end of the input and a *RecordError if the record is malformed.
+func (r *WarcReader) ReadRecord() (*WarcRecord, error) {
+
+	if err := r.discardContent(); err != nil {
+		return nil, r.recordError(r.last, err)
+	}
+
+	offset, line, err := r.readFirstLine()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, r.recordError(&WarcRecord{Offset: offset}, err)
+	}
+
+	if r.arc {
+		header, err := parseArcHeaderLine(line)
+		if err != nil {
+			return nil, r.recordError(&WarcRecord{Offset: offset}, err)
+		}
+		return r.startContent(&WarcRecord{Header: header, Offset: offset})
+	}
+	if !strings.HasPrefix(line, "WARC/") {
+		return nil, r.recordError(&WarcRecord{Offset: offset}, errNotWarcVersion)
+	}
+
+	record := &WarcRecord{Header: WarcHeader{}, Offset: offset}
+	r.last = record
+
+	var lastKey string
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, r.recordError(record, err)
+		}
+		if len(line) == 0 {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// Continuation of the previous field
+			if lastKey != "" {
+				record.Header[lastKey] += " " + strings.TrimSpace(line)
+			}
+			continue
+		}
+		separator := strings.IndexByte(line, ':')
+		if separator < 1 {
+			return nil, r.recordError(record, fmt.Errorf("malformed header line %q", line))
+		}
+		lastKey = strings.ToLower(strings.TrimSpace(line[:separator]))
+		record.Header[lastKey] = strings.TrimSpace(line[separator+1:])
+	}
+
+	return r.startContent(record)
+}
+
+// Makes the content of the record, as long as its Content-Length, readable
+func (r *WarcReader) startContent(record *WarcRecord) (*WarcRecord, error) {
+	r.last = record
+	length, err := strconv.ParseInt(record.Header.Get("content-length"), 10, 64)
+	if err != nil || length < 0 {
+		return nil, r.recordError(record, fmt.Errorf("invalid Content-Length %q", record.Header.Get("content-length")))
+	}
+
+	r.content = &io.LimitedReader{R: r.records, N: length}
+	record.Content = r.content
+	return record, nil
+}
+
+// Consumes what is left of the content of the last record
+func (r *WarcReader) discardContent() error {
+	if r.content != nil {
+		_, err := io.Copy(ioutil.Discard, r.content)
+		r.content = nil
+		if err != nil {
+			r.contentErr = err
+		}
+	}
+	err := r.contentErr
+	r.contentErr = nil
+	return err
+}
+
+// Returns the number of bytes the last record takes in the input, the blank lines
+// after it included, so that it can be read again seeking its offset. The rest of
+// its content is consumed. For gzipped WARCs it is the compressed length of the member,
+// and it is -1 when the member holds other records too and on errors.
+// The same holds for the frames of zstd WARCs.
+func (r *WarcReader) RecordLength() int64 {
+	if r.last == nil {
+		return -1
+	}
+	if r.content != nil {
+		if _, err := io.Copy(ioutil.Discard, r.content); err != nil {
+			// Reported by the next ReadRecord
+			r.contentErr = err
+		}
+		r.content = nil
+	}
+	if r.contentErr != nil {
+		return -1
+	}
+
+	for {
+		next, err := r.records.Peek(1)
+		if err == io.EOF {
+			// End of the input or of the gzip member, whose trailer has been consumed
+			return r.position() - r.last.Offset
+		}
+		if err != nil {
+			return -1
+		}
+		if next[0] != '\r' && next[0] != '\n' {
+			break
+		}
+		r.records.Discard(1)
+	}
+
+	if r.members {
+		return -1
+	}
+	return r.position() - r.last.Offset
+}
+
+// Skips the blank lines separating two records and consumes the first line of the next
+// one, the version line of WARC records or the header line of ARC records.
+// It returns the offset of the record and the line.
+func (r *WarcReader) readFirstLine() (int64, string, error) {
+	for {
+		if r.members {
+			if err := r.nextMember(); err != nil {
+				return r.memberOffset, "", err
+			}
+		}
+
+		offset := r.recordOffset()
+		line, err := r.readLine()
+		if err != nil {
+			if err == io.EOF && len(line) == 0 && r.members {
+				continue
+			}
+			return offset, "", err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		return offset, line, nil
+	}
+}
+
+// Offset of the record starting at the current position
+func (r *WarcReader) recordOffset() int64 {
+	if r.members {
+		return r.memberOffset
+	}
+	return r.position()
+}
+
+// Reads a line of the record header without the line terminator
+func (r *WarcReader) readLine() (string, error) {
+	line, err := r.records.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return line, err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (r *WarcReader) recordError(record *WarcRecord, err error) *RecordError {
+	recordError := &RecordError{Err: err}
+	if record != nil {
+		recordError.Offset = record.Offset
+		if record.Header != nil {
+			recordError.RecordID = record.Header.Get("warc-record-id")
+		}
+	}
+	return recordError
+}
+
+// Moves the reader to the beginning of the next record after a failure, that is the next
+// line starting with the WARC version, or looking like an ARC header line, or, for gzipped
+// and zstd WARCs, the next member if the current one is corrupted. It returns io.EOF if there are
+// no more records.
+func (r *WarcReader) Resync() error {
+	r.content = nil
+	r.contentErr = nil
+
+	for {
+		var err error
+		if r.arc {
+			var line []byte
+			line, err = r.records.Peek(MAX_ARC_HEADER_LINE)
+			if end := bytes.IndexByte(line, '\n'); end >= 0 && isArcHeaderLine(string(line[:end])) {
+				return nil
+			}
+			if err == io.EOF && len(line) > 0 {
+				err = nil
+			}
+		} else {
+			var prefix []byte
+			prefix, err = r.records.Peek(len("WARC/1."))
+			if err == nil && string(prefix) == "WARC/1." {
+				return nil
+			}
+		}
+		if err == nil || err == bufio.ErrBufferFull {
+			err = r.skipLine()
+		}
+		if err == io.EOF && r.members {
+			// The next read moves to the next member
+			return nil
+		}
+		if err != nil {
+			if r.members && err != io.ErrUnexpectedEOF {
+				return r.resyncMember()
+			}
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return err
+		}
+	}
+}
+
+// Consumes the input until the end of the current line
+func (r *WarcReader) skipLine() error {
+	for {
+		line, err := r.records.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			return nil
+		}
+		return err
+	}
+}
+
+// Looks for the next gzip or zstd header in the input when the current member is corrupted
+func (r *WarcReader) resyncMember() error {
+	memberMagic := gzipMagic
+	if r.zstd != nil {
+		memberMagic = zstdMagic
+	}
+	for {
+		magic, err := r.input.Peek(len(memberMagic))
+		if err != nil {
+			if err == io.EOF {
+				return io.EOF
+			}
+			return err
+		}
+		if bytes.Equal(magic, memberMagic) {
+			r.memberOffset = r.position()
+			if err := r.resetMember(); err == nil {
+				return nil
+			}
+			// False positive, keep searching after the bytes consumed by the header
+			if r.position() > r.memberOffset {
+				continue
+			}
+		}
+		r.input.Discard(1)
+	}
+}
+
+// Releases the resources of the reader. The underlying reader is not closed.
+func (r *WarcReader) Close() {
+	if r.gzip != nil {
+		r.gzip.Close()
+	}
+	if r.zstd != nil {
+		r.zstd.Close()
+	}
+}
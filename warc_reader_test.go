@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// Compresses each record in its own gzip member, as in Common Crawl WARCs.
+// It returns the data and the offset of each member.
+func gzipMembers(records ...string) ([]byte, []int64) {
+	var buffer bytes.Buffer
+	var offsets []int64
+	for _, record := range records {
+		offsets = append(offsets, int64(buffer.Len()))
+		gz := gzip.NewWriter(&buffer)
+		gz.Write([]byte(record))
+		gz.Close()
+	}
+	return buffer.Bytes(), offsets
+}
+
+func readTargetUri(t *testing.T, reader *WarcReader) (*WarcRecord, string) {
+	record, err := reader.ReadRecord()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	content, err := ioutil.ReadAll(record.Content)
+	if err != nil {
+		t.Fatalf("unexpected error reading the content: %s", err)
+	}
+	if !strings.HasPrefix(string(content), "HTTP/1.1 200") {
+		t.Errorf("unexpected content %q", content)
+	}
+	return record, record.Header.Get("WARC-Target-URI")
+}
+
+func TestWarcReaderHeader(t *testing.T) {
+	record := "WARC/1.0\r\n" +
+		"warc-type: response\r\n" +
+		"WARC-Target-URI: http://example.com/\r\n" +
+		"X-Folded: first\r\n  second\r\n" +
+		"Content-Length: 4\r\n" +
+		"\r\nbody\r\n\r\n"
+
+	reader, err := NewWarcReader(strings.NewReader(record))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := reader.ReadRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.Get("WARC-Type") != "response" {
+		t.Errorf("unexpected type %q", parsed.Header.Get("WARC-Type"))
+	}
+	if parsed.Header.Get("x-folded") != "first second" {
+		t.Errorf("unexpected folded header %q", parsed.Header.Get("x-folded"))
+	}
+	if _, err := reader.ReadRecord(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestWarcReaderResync(t *testing.T) {
+	page := "HTTP/1.1 200 OK\r\n\r\nhello"
+	first := testWarcResponse("http://example.com/1", page)
+	broken := strings.Replace(testWarcResponse("http://example.com/2", page), "Content-Length", "Content-Lenght", 1)
+	third := testWarcResponse("http://example.com/3", page)
+
+	reader, err := NewWarcReader(strings.NewReader(first + broken + third))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, uri := readTargetUri(t, reader); uri != "http://example.com/1" {
+		t.Errorf("unexpected first record %s", uri)
+	}
+
+	_, err = reader.ReadRecord()
+	var recordErr *RecordError
+	if !errors.As(err, &recordErr) {
+		t.Fatalf("expected a RecordError, got %v", err)
+	}
+	if recordErr.Offset != int64(len(first)) || !strings.HasPrefix(recordErr.RecordID, "<urn:uuid:") {
+		t.Errorf("unexpected error position %d %q", recordErr.Offset, recordErr.RecordID)
+	}
+
+	if err := reader.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	record, uri := readTargetUri(t, reader)
+	if uri != "http://example.com/3" || record.Offset != int64(len(first+broken)) {
+		t.Errorf("unexpected record %s at %d", uri, record.Offset)
+	}
+	if _, err := reader.ReadRecord(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestWarcReaderGzipResync(t *testing.T) {
+	page := "HTTP/1.1 200 OK\r\n\r\nhello"
+	data, offsets := gzipMembers(
+		testWarcResponse("http://example.com/1", page),
+		testWarcResponse("http://example.com/2", page),
+		testWarcResponse("http://example.com/3", page))
+
+	// Corrupt the deflate stream of the second member
+	corrupted := append([]byte(nil), data...)
+	for i := offsets[1] + 12; i < offsets[1]+40; i++ {
+		corrupted[i] ^= 0xff
+	}
+
+	reader, err := NewWarcReader(bytes.NewReader(corrupted))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, uri := readTargetUri(t, reader)
+	if uri != "http://example.com/1" || record.Offset != 0 {
+		t.Errorf("unexpected record %s at %d", uri, record.Offset)
+	}
+
+	_, err = reader.ReadRecord()
+	var recordErr *RecordError
+	if !errors.As(err, &recordErr) || recordErr.Offset != offsets[1] {
+		t.Fatalf("expected a RecordError at %d, got %v", offsets[1], err)
+	}
+
+	if err := reader.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	record, uri = readTargetUri(t, reader)
+	if uri != "http://example.com/3" || record.Offset != offsets[2] {
+		t.Errorf("unexpected record %s at %d", uri, record.Offset)
+	}
+	if _, err := reader.ReadRecord(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestWarcReaderRecordLength(t *testing.T) {
+	first := testWarcResponse("http://example.com/first", "HTTP/1.1 200 OK\r\n\r\nfirst")
+	second := testWarcResponse("http://example.com/second", "HTTP/1.1 200 OK\r\n\r\nsecond")
+
+	gzipped, offsets := gzipMembers(first, second)
+	inputs := map[string]struct {
+		data    []byte
+		offsets []int64
+	}{
+		"plain": {[]byte(first + second), []int64{0, int64(len(first))}},
+		"gzip":  {gzipped, offsets},
+	}
+
+	for name, input := range inputs {
+		reader, err := NewWarcReader(bytes.NewReader(input.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, offset := range input.offsets {
+			record, err := reader.ReadRecord()
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", name, err)
+			}
+			length := reader.RecordLength()
+			if record.Offset != offset || length <= 0 {
+				t.Fatalf("%s: record %d at %d with length %d, expected offset %d", name, i, record.Offset, length, offset)
+			}
+
+			// The record can be read again from its offset and length alone
+			replay, err := NewWarcReader(bytes.NewReader(input.data[offset : offset+length]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			replayed, targetUri := readTargetUri(t, replay)
+			if targetUri != record.Header.Get("WARC-Target-URI") || replayed.Offset != 0 {
+				t.Errorf("%s: replayed %s, expected %s", name, targetUri, record.Header.Get("WARC-Target-URI"))
+			}
+			if _, err := replay.ReadRecord(); err != io.EOF {
+				t.Errorf("%s: expected a single record, got %v", name, err)
+			}
+		}
+		if _, err := reader.ReadRecord(); err != io.EOF {
+			t.Errorf("%s: expected EOF, got %v", name, err)
+		}
+	}
+
+	// Both records in the same member
+	shared, _ := gzipMembers(first + second)
+	reader, err := NewWarcReader(bytes.NewReader(shared))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.ReadRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if length := reader.RecordLength(); length != -1 {
+		t.Errorf("expected an unknown length for a shared member, got %d", length)
+	}
+}
@@ -4,20 +4,51 @@ import (
 	"bufio"
 	"fmt"
 	"github.com/PuerkitoBio/purell"
-	"github.com/slyrz/warc"
 	"github.com/tevino/abool"
-	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/parquet"
-	"github.com/xitongsys/parquet-go/writer"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/charset"
 	"io"
+	"io/ioutil"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
-const CHUNK_SIZE = 500000
+
+// Options of the extraction shared by all the WARC files of a job
+type ExtractionConfig struct {
+	// Number of malformed records skipped before giving up on the WARC
+	MaxRecordErrors int
+	// Copy the links of the original response to the revisit records with the same payload digest
+	CopyRevisitLinks bool
+	// Number of goroutines parsing the HTML pages of a WARC
+	ParsersCount int
+	// Collect the markers as soon as the pages are parsed instead of in the order of the records
+	Unordered bool
+	// Memory for the records and markers queued in the pipeline, it can be shared by several
+	// WARC files processed in parallel. A default budget is used if it is nil.
+	MemoryBudget *MemoryBudget
+	// Format of the output, Parquet if empty, and compression of the text formats
+	Format      string
+	Compression string
+	// Options of the Parquet writer, the defaults are used if it is nil
+	Parquet *ParquetOptions
+	// Options of the inputs fetched over HTTP(S) or from S3, the defaults are used if it is nil
+	Http *HttpOptions
+}
+
+// Format and compression of the output, with their defaults
+func (c ExtractionConfig) outputFormat() (string, string) {
+	format, compression := c.Format, c.Compression
+	if len(format) == 0 {
+		format = FORMAT_PARQUET
+	}
+	if len(compression) == 0 {
+		compression = COMPRESSION_NONE
+	}
+	return format, compression
+}
 
 const PURELL_FLAGS = purell.FlagsUsuallySafeGreedy |
 	purell.FlagForceHTTP |
@@ -25,14 +56,39 @@ const PURELL_FLAGS = purell.FlagsUsuallySafeGreedy |
 	purell.FlagSortQuery
 
 func getAbsoluteNormalized(pageUrl *url.URL, href string) (string, string) {
+	normalized, fragment, _ := resolveLink(pageUrl, href)
+	return normalized, fragment
+}
+
+// Resolves the href against the URL of the page or of its <base> and normalizes it.
+// It also tells if the resolved URL is https, the scheme is lost once normalized.
+func resolveLink(baseUrl *url.URL, href string) (string, string, bool) {
 	hrefUrl, err := url.Parse(href)
 	var fragment string
 	if err == nil {
 		fragment = hrefUrl.Fragment
-		hrefUrl = pageUrl.ResolveReference(hrefUrl)
-		return purell.NormalizeURL(hrefUrl, PURELL_FLAGS), fragment
+		hrefUrl = baseUrl.ResolveReference(hrefUrl)
+		secure := hrefUrl.Scheme == "https"
+		return purell.NormalizeURL(hrefUrl, PURELL_FLAGS), fragment, secure
+	}
+	return "", fragment, false
+}
+
+// Resolves the href of a <base> element against the page URL.
+// It returns nil if the result is not an absolute HTTP(S) URL.
+func getBaseUrl(pageUrl *url.URL, href string) *url.URL {
+	if len(href) == 0 {
+		return nil
 	}
-	return "", fragment
+	hrefUrl, err := url.Parse(href)
+	if err != nil {
+		return nil
+	}
+	baseUrl := pageUrl.ResolveReference(hrefUrl)
+	if (baseUrl.Scheme != "http" && baseUrl.Scheme != "https") || len(baseUrl.Host) == 0 {
+		return nil
+	}
+	return baseUrl
 }
 
 func sanitizeString(rawUrl string) string {
@@ -50,115 +106,213 @@ func getCharsetReader(reader *bufio.Reader, contentType string) io.Reader {
 	return encoding.NewDecoder().Reader(reader)
 }
 
-func LinkExtractionWorker(inputWarcFile, outputParquet, dataOrigin string, logger Logger) {
+// Checks if the Content-Type header declares an HTML document
+func isHtml(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(contentType))
+	return strings.HasPrefix(mediaType, "text/html") || strings.HasPrefix(mediaType, "application/xhtml+xml")
+}
+
+// Extracts the markers of the WARC file, of the HTTP(S) URL or of the standard input for
+// STDIN_PATH, and writes them in the output file, it returns the rows and bytes written.
+// Plain, gzip and zstd WARCs are detected from their content. The returned error
+// is an *InputError, a *RecordError or a *WriterError. In any case the output is finalized with the markers extracted until the failure.
+func LinkExtractionWorker(inputWarcFile, outputFile, dataOrigin string, config ExtractionConfig, logger Logger) (OutputStats, error) {
 
-	fileReader, err := os.Open(inputWarcFile)
+	httpOptions := DefaultHttpOptions()
+	if config.Http != nil {
+		httpOptions = *config.Http
+	}
+	fileReader, err := openInput(inputWarcFile, httpOptions)
 	if err != nil {
 		logger.Exceptions <- Exception{
 			ErrorType:       "File not found",
 			Message:         inputWarcFile,
 			OriginalMessage: err.Error(),
 		}
-		panic(err)
-
-	} else {
+		return OutputStats{}, &InputError{Path: inputWarcFile, Err: err}
+	}
+	defer fileReader.Close()
 
-		recordsReader, err := warc.NewReader(fileReader)
-		if err != nil {
-			logger.Exceptions <- Exception{
-				//File:            sourceFile,
-				//Source:          exceptionsSource,
-				ErrorType:       "WARC Reader failed",
-				Message:         inputWarcFile,
-				OriginalMessage: err.Error(),
-			}
-			panic(err)
+	recordsReader, err := NewWarcReader(fileReader)
+	if err != nil {
+		logger.Exceptions <- Exception{
+			//File:            sourceFile,
+			//Source:          exceptionsSource,
+			ErrorType:       "WARC Reader failed",
+			Message:         inputWarcFile,
+			OriginalMessage: err.Error(),
+		}
+		return OutputStats{}, &InputError{Path: inputWarcFile, Err: err}
+	}
+	defer recordsReader.Close()
 
-		} else {
+	if config.MemoryBudget == nil {
+		config.MemoryBudget = NewMemoryBudget(DEFAULT_MEMORY_BUDGET)
+	}
+	parquetOptions := DefaultParquetOptions()
+	if config.Parquet != nil {
+		parquetOptions = *config.Parquet
+	}
 
+	format, compression := config.outputFormat()
+	sink, err := NewSink(outputFile, inputWarcFile, format, compression, parquetOptions, httpOptions)
+	if err != nil {
+		logger.Exceptions <- Exception{
+			//Source:          exceptionsSource,
+			ErrorType:       "Write failed",
+			Message:         "Impossible to create the file",
+			OriginalMessage: err.Error(),
+		}
+		return OutputStats{}, err
+	}
 
+	// Channel to share the chucks to write, their size is bounded by the memory budget
+	writerChannel := make(chan *MarkerBatch, WRITER_QUEUE_LENGTH)
 
+	// Synchronized boolean var to inform the reader if the writer failed
+	failedWriterFlag := abool.New()
 
-			// Channel to share the chucks to write
-			writerChannel := make(chan *MarkersList, 150)
+	// Get the outcome of the writer when it completed the job
+	writerDone := make(chan error, 1)
 
-			// Synchronized boolean var to inform the reader if the writer failed
-			failedWriterFlag := abool.New()
+	// - The writer runs waiting from links chunks from the channel
+	// - If it fails, it sets the failedWriterFlag to TRUE and log the error
+	// - The reader checks regularly the flag, if it's TRUE: break
+	go WriteMarkers(sink, writerChannel, config.MemoryBudget, failedWriterFlag, writerDone, logger)
 
-			// Get a message when the writer completed the job
-			writerDone := make(chan bool)
+	// The reader ended, the file if completely processed and we can inform the writer by
+	// closing the channel, then wait for it to complete. It is stopped also if the reader
+	// panics, before the panic reaches the caller.
+	var writerErr error
+	var writerStopped sync.Once
+	stopWriter := func() {
+		writerStopped.Do(func() {
+			close(writerChannel)
+			writerErr = <-writerDone
+		})
+	}
+	defer stopWriter()
 
-			// - The writer runs waiting from links chunks from the channel
-			// - If it fails, it sets the failedWriterFlag to TRUE and log the error
-			// - The reader checks regularly the flag, if it's TRUE: break
-			go WriteParquet(outputParquet, writerChannel, failedWriterFlag, writerDone, logger)
+	readerErr := ReadWarc(dataOrigin, inputWarcFile, recordsReader, writerChannel, failedWriterFlag, config, logger)
+	stopWriter()
 
-			ReadWarc(dataOrigin, recordsReader, writerChannel, failedWriterFlag, logger)
+	if writerErr != nil {
+		return sink.Stats(), writerErr
+	}
+	return sink.Stats(), readerErr
+}
 
-			// The reader ended, the file if completely processed and we can
-			// inform the writer by closing the channel
-			close(writerChannel)
 
-			// Wait for the writer to complete
-			<-writerDone
 
-		}
 
-		recordsReader.Close()
-		fileReader.Close()
+// Reads the records of the WARC and sends the extracted markers to the writer in chunks.
+// The markers reference the record they come from in warcFile. Revisit records get a page marker too.
+// Request and metadata records are joined to their response to tell how the crawler reached the page.
+// The records are read sequentially, their HTML pages are parsed by config.ParsersCount goroutines
+// and the markers are collected in the order of the records, unless config.Unordered is set.
+// The reader waits when the records and markers queued exceed config.MemoryBudget.
+// Malformed records are logged and skipped until more than config.MaxRecordErrors are found,
+// then it stops returning a *RecordError, after sending the markers collected so far.
+func ReadWarc(dataOrigin string, warcFile string, recordsReader *WarcReader, writersChannel chan *MarkerBatch,
+	failedWriterFlag *abool.AtomicBool, config ExtractionConfig, logger Logger) error {
 
+	if config.MemoryBudget == nil {
+		config.MemoryBudget = NewMemoryBudget(DEFAULT_MEMORY_BUDGET)
+	}
+	parsersCount := config.ParsersCount
+	if parsersCount < 1 {
+		parsersCount = 1
 	}
 
-}
-
-
+	recordsChannel := make(chan *recordResult, parsersCount)
+	parsedChannel := make(chan *recordResult, parsersCount)
+	collected := make(chan bool)
 
+	var parsersWaitGroup sync.WaitGroup
+	for i := 0; i < parsersCount; i++ {
+		parsersWaitGroup.Add(1)
+		go ParseRecords(recordsChannel, parsedChannel, config.MemoryBudget, logger, &parsersWaitGroup)
+	}
+	go func() {
+		parsersWaitGroup.Wait()
+		close(parsedChannel)
+	}()
+	go CollectMarkers(parsedChannel, writersChannel, config, collected)
+
+	// Wait for the records already read to be parsed and their markers sent to the writer,
+	// also when the reader panics, so that no stage logs after the caller recovers
+	defer func() {
+		close(recordsChannel)
+		<-collected
+	}()
+	return readRecords(dataOrigin, warcFile, recordsReader, recordsChannel, failedWriterFlag, config, logger)
+}
 
-func ReadWarc(dataOrigin string, recordsReader *warc.Reader, writersChannel chan *MarkersList,
-	failedWriterFlag *abool.AtomicBool, logger Logger) {
-	markersBuffer := MarkersList{}
+// Reads the records of the WARC and sends them to the parsers with the markers
+// found in the WARC and HTTP headers and the HTML page to parse
+func readRecords(dataOrigin string, warcFile string, recordsReader *WarcReader, recordsChannel chan *recordResult,
+	failedWriterFlag *abool.AtomicBool, config ExtractionConfig, logger Logger) error {
+	recordErrors := 0
+	recordsCount := 0
 
 	for {
-		if markersBuffer.length >= CHUNK_SIZE {
-
-			// If the writer is dead, stop the reader
-			if failedWriterFlag.IsSet() {
-				//LOG FAILED
-				logger.Exceptions <- Exception{
-					//File:      path,
-					//Source:    exceptionsSource,
-					ErrorType: "Reader controlled failure",
-					Message:   "The writer failed and the reader is interrupting the job",
-				}
-				break
+		// If the writer is dead, stop the reader
+		if failedWriterFlag.IsSet() {
+			//LOG FAILED
+			logger.Exceptions <- Exception{
+				//File:      path,
+				//Source:    exceptionsSource,
+				ErrorType: "Reader controlled failure",
+				Message:   "The writer failed and the reader is interrupting the job",
 			}
-
-			// Send the chunk and allocate a new list
-			copied := markersBuffer.copy()
-			writersChannel <- &copied
-			markersBuffer = MarkersList{}
+			break
 		}
 
 		record, err := recordsReader.ReadRecord()
 		if err != nil {
 			if err != io.EOF {
+				recordErr, ok := err.(*RecordError)
+				if !ok {
+					recordErr = &RecordError{Offset: -1, Err: err}
+				}
 				logger.Exceptions <- Exception{
 					//File:            path,
 					//Source:          exceptionsSource,
 					ErrorType:       "Record malformed",
-					Message:         "The reader failed to process the record",
-					OriginalMessage: err.Error(),
+					Message:         fmt.Sprintf("Record %s at offset %d", recordErr.RecordID, recordErr.Offset),
+					OriginalMessage: recordErr.Err.Error(),
+				}
+
+				recordErrors++
+				if recordErrors > config.MaxRecordErrors {
+					return recordErr
+				}
+
+				// Skip the broken record and continue from the next one
+				if err := recordsReader.Resync(); err != nil {
+					if err == io.EOF {
+						break
+					}
+					return &RecordError{Offset: recordErr.Offset, RecordID: recordErr.RecordID, Err: err}
 				}
-				panic(err)
 			} else {
 				break
 			}
 		} else {
-
+			result := &recordResult{sequence: recordsCount, header: record.Header}
+			recordsCount++
 			warcContentType := record.Header.Get("content-type")
 			recordType := record.Header.Get("warc-type")
 
-			if recordType == "response" && strings.HasPrefix(warcContentType, "application/http") {
+			isRevisit := recordType == "revisit"
+			// The content of a revisit record is at most the HTTP header of the response, or nothing
+			hasHttpResponse := strings.HasPrefix(warcContentType, "application/http") ||
+				(isRevisit && strings.HasPrefix(warcContentType, "message/http"))
+			if isRevisit && record.Header.Get("Content-Length") == "0" {
+				hasHttpResponse = false
+			}
+
+			if (recordType == "response" && hasHttpResponse) || isRevisit {
 				recordDate, err := time.Parse(time.RFC3339, record.Header.Get("warc-date"))
 				if err != nil {
 					logger.Exceptions <- Exception{
@@ -195,50 +349,65 @@ func ReadWarc(dataOrigin string, recordsReader *warc.Reader, writersChannel chan
 
 						normalizedPageUrl := purell.NormalizeURL(pageUrl, PURELL_FLAGS)
 
-						reader := bufio.NewReader(record.Content)
 						var httpStatusCode string
 						var redirectLocation string
 						var contentType string
 
-						for {
-							lineBytes, _, err := reader.ReadLine()
-
-							if err == io.EOF {
-								break
-							}
-							if len(lineBytes) < 1 {
-								break
-							}
-
-							line := string(lineBytes)
-
-							if strings.HasPrefix(line, "HTTP/") {
-								if len(line) >= 12 {
-									httpStatusCode = line[9:12]
-								}
-							}
-
-							if strings.HasPrefix(line, "Location:") {
-								if len(line) >= 10 {
-									redirectLocation = line[10:]
+						var response *HttpResponse
+						payload := bufio.NewReader(record.Content)
+						if hasHttpResponse {
+							response, err = ReadHttpResponseHeader(payload)
+							if err != nil {
+								logger.Exceptions <- Exception{
+									SourcePage:      normalizedPageUrl,
+									ErrorType:       "HTTP response malformed",
+									Message:         record.Header.Get("WARC-Record-ID"),
+									OriginalMessage: err.Error(),
 								}
-							}
-
-							if strings.HasPrefix(line, "Content-Type:") {
-								if len(line) >= 14 {
-									contentType = line[14:]
+							} else {
+								httpStatusCode = response.StatusCode
+								redirectLocation = response.Header.Get("Location")
+								contentType = response.Header.Get("Content-Type")
+
+								// Redirect with the Refresh header, sent also with 200 responses
+								if refresh := response.Header.Get("Refresh"); len(refresh) > 0 {
+									delay, target, ok := parseRefresh(refresh)
+									if ok && len(target) > 0 {
+										normalizedTarget, fragment := getAbsoluteNormalized(pageUrl, sanitizeString(target))
+										if len(normalizedTarget) > 0 {
+											link := NewRedirectMarker(recordDate.Unix(), invertedPageHost, isSecure || strings.HasPrefix(target, "https:"),
+												normalizedPageUrl, normalizedTarget, fragment, REFRESH_HEADER_TAG, delay, dataOrigin)
+											link.WarcFile = warcFile
+											link.WarcOffset = record.Offset
+											result.markers = append(result.markers, link)
+										}
+									}
 								}
 							}
-
 						}
 
 						extras := ""
-						if httpStatusCode == "200" {
-
-							if strings.HasPrefix(contentType, "text/html") {
-								customReader := getCharsetReader(reader, contentType)
-								pageLinks := getLinks(dataOrigin, recordDate.Unix(), pageUrl, &normalizedPageUrl, customReader, logger, isSecure, invertedPageHost)
-								markersBuffer.appendList(pageLinks)
+						if httpStatusCode == "200" || (isRevisit && len(httpStatusCode) == 0) {
+
+							if isRevisit {
+								result.copyRevisit = config.CopyRevisitLinks
+							} else if isHtml(contentType) {
+								// The page is parsed by the parsers, the reader only keeps the raw body
+								body, err := ioutil.ReadAll(payload)
+								if err != nil {
+									logger.Exceptions <- Exception{
+										SourcePage:      normalizedPageUrl,
+										ErrorType:       "Body reading failed",
+										OriginalMessage: err.Error(),
+									}
+								}
+								result.html = &htmlPage{
+									pageUrl:           pageUrl,
+									normalizedPageUrl: normalizedPageUrl,
+									invertedPageHost:  invertedPageHost,
+									response:          response,
+									body:              body,
+								}
 							}
 
 						} else {
@@ -253,26 +422,84 @@ func ReadWarc(dataOrigin string, recordsReader *warc.Reader, writersChannel chan
 						// Add the marker to know that the crawler visited the page
 						link := NewWebpageMarker(recordDate.Unix(), invertedPageHost, isSecure,
 							normalizedPageUrl, httpStatusCode, extras, dataOrigin)
-						markersBuffer.append(&link)
+						link.WarcFile = warcFile
+						link.WarcOffset = record.Offset
+						link.WarcLength = recordsReader.RecordLength()
+						link.WarcRecordId = record.Header.Get("WARC-Record-ID")
+						link.WarcPayloadDigest = record.Header.Get("WARC-Payload-Digest")
+						if isRevisit {
+							link.Revisit = true
+							link.RefersToUri, link.RefersToDate = getRevisitReference(record.Header)
+						}
+						result.page = &link
 
 					}
 
 				}
+			} else if recordType == "request" && strings.HasPrefix(warcContentType, "application/http") {
+				info, err := readRequestInfo(record.Content)
+				if err != nil {
+					logger.Exceptions <- Exception{
+						SourcePage:      record.Header.Get("WARC-Target-URI"),
+						ErrorType:       "HTTP request malformed",
+						Message:         record.Header.Get("WARC-Record-ID"),
+						OriginalMessage: err.Error(),
+					}
+				} else {
+					result.info = &info
+				}
+			} else if recordType == "metadata" {
+				info := readMetadataInfo(record.Content)
+				result.info = &info
 			}
 
+			// Every record is sent, the request and metadata records are joined by the collector.
+			// The records read and not yet collected wait for the budget, which bounds also
+			// the records parsed out of order waiting for the previous ones.
+			result.size = result.estimatedSize()
+			config.MemoryBudget.acquire(result.size)
+			recordsChannel <- result
+
 		}
 	}
-	writersChannel <- &markersBuffer
-
+	return nil
 }
 
 
 func getLinks(dataOrigin string, crawlingTime int64, pageUrl *url.URL,
 	normalizedPageUrl *string, body io.Reader, logger Logger,
-	mainPageSecure bool, invertedPageHost string) *MarkersList {
+	invertedPageHost string) (*MarkerBatch, string) {
 
 	//Links in the current page
-	pageLinks := MarkersList{}
+	pageLinks := NewMarkerBatch()
+
+	// URL the relative links are resolved against, it changes if the page declares a valid <base>
+	baseUrl := pageUrl
+	baseOverride := false
+
+	// Canonical URL declared by the first <link rel="canonical">
+	canonical := ""
+
+	// Creates the marker of a link, false if the link cannot be normalized
+	newLink := func(tag, hrefValue, extras string) (Marker, bool) {
+		normalizedHrefValue, fragment, isSecure := resolveLink(baseUrl, hrefValue)
+
+		if len(normalizedHrefValue) > 0 {
+			link := NewMarker(
+				crawlingTime,
+				invertedPageHost,
+				isSecure,
+				*normalizedPageUrl,
+				normalizedHrefValue,
+				fragment,
+				tag,
+				truncateExtras(extras),
+				dataOrigin)
+			link.BaseOverride = baseOverride
+			return link, true
+		}
+		return Marker{}, false
+	}
 
 	//Initialise tokenizer
 	tokenizer := html.NewTokenizer(body)
@@ -286,6 +513,7 @@ func getLinks(dataOrigin string, crawlingTime int64, pageUrl *url.URL,
 			token := tokenizer.Token()
 			// Tag a
 			if "a" == token.Data {
+				attributes := token.Attr
 				var hrefValue string
 				for _, attr := range token.Attr {
 					if attr.Key == "href" {
@@ -301,11 +529,7 @@ func getLinks(dataOrigin string, crawlingTime int64, pageUrl *url.URL,
 				if !strings.HasPrefix(hrefValue, "javascript:") &&
 					!strings.HasPrefix(hrefValue, "#") {
 
-					isSecure := mainPageSecure
-					if strings.HasPrefix(hrefValue, "https:") {
-						isSecure = true
-					}
-					normalizedHrefValue, fragment := getAbsoluteNormalized(pageUrl, hrefValue)
+					normalizedHrefValue, fragment, isSecure := resolveLink(baseUrl, hrefValue)
 					//fmt.Println(normalizedHrefValue)
 					if len(normalizedHrefValue) > 0 {
 
@@ -319,15 +543,12 @@ func getLinks(dataOrigin string, crawlingTime int64, pageUrl *url.URL,
 							} else if tokenType == html.EndTagToken && token.Data == "a" {
 								break
 							} else if tokenType == html.ErrorToken {
-								return &pageLinks
+								return pageLinks, canonical
 
 							}
 						}
 
-						extrasString := extras.String()
-						if len(extrasString) > 256 {
-							extrasString = extrasString[:256]
-						}
+						extrasString := truncateExtras(extras.String())
 
 						link := NewMarker(
 							crawlingTime,
@@ -339,8 +560,10 @@ func getLinks(dataOrigin string, crawlingTime int64, pageUrl *url.URL,
 							token.Data,
 							extrasString,
 							dataOrigin)
+						link.BaseOverride = baseOverride
+						setHyperlinkAttributes(&link, attributes)
 
-						pageLinks.append(&link)
+						pageLinks.Append(&link)
 					} else {
 						//LINK NORMALIZATION FAILED
 						logger.Exceptions <- Exception{
@@ -352,145 +575,181 @@ func getLinks(dataOrigin string, crawlingTime int64, pageUrl *url.URL,
 
 				}
 
-			} else if "link" == token.Data ||
-				"area" == token.Data ||
-				"form" == token.Data ||
-				"script" == token.Data {
+			} else if "link" == token.Data {
 
-				var hrefValue string
-				var extrasValue string
-
-				var hrefKey string
-				var extrasKey string
-				linkAttributeFound := false
-
-				switch token.Data {
-				case "link":
-					hrefKey = "href"
-					extrasKey = "rel"
-				case "script":
-					hrefKey = "src"
-					extrasKey = "type"
-				case "form":
-					hrefKey = "action"
-					extrasKey = "method"
-				case "area":
-					hrefKey = "href"
-					extrasKey = "alt"
+				var hrefValue, rel, hreflang, media, linkType string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "href":
+						hrefValue = sanitizeString(attr.Val)
+					case "rel":
+						rel = normalizeRel(toValidUTF8(attr.Val))
+					case "hreflang":
+						hreflang = strings.ToLower(strings.TrimSpace(toValidUTF8(attr.Val)))
+					case "media":
+						media = strings.TrimSpace(attr.Val)
+					case "type":
+						linkType = strings.TrimSpace(attr.Val)
+					}
 				}
 
+				if len(hrefValue) > 0 {
+					// Alternate versions for other devices are told apart by the media,
+					// feeds and other formats by the type
+					extras := media
+					if len(extras) == 0 {
+						extras = linkType
+					}
+
+					if link, ok := newLink(token.Data, hrefValue, extras); ok {
+						link.Rel = rel
+						link.Hreflang = hreflang
+						if len(canonical) == 0 && hasRelToken(rel, "canonical") {
+							canonical = link.Link
+						}
+						pageLinks.Append(&link)
+					}
+				}
+
+			} else if "meta" == token.Data {
+
+				var httpEquiv string
+				var content string
 				for _, attr := range token.Attr {
-					if attr.Key == hrefKey {
-						hrefValue = strings.TrimSpace(attr.Val)
-						linkAttributeFound = true
-					} else if attr.Key == extrasKey {
-						extrasValue = attr.Val
+					if attr.Key == "http-equiv" {
+						httpEquiv = strings.TrimSpace(attr.Val)
+					} else if attr.Key == "content" {
+						content = attr.Val
+					}
+				}
+				if strings.EqualFold(httpEquiv, "refresh") {
+					delay, target, ok := parseRefresh(content)
+					if ok && len(target) > 0 {
+						if link, ok := newLink(META_REFRESH_TAG, sanitizeString(target), delay); ok {
+							pageLinks.Append(&link)
+						}
 					}
 				}
 
-				if linkAttributeFound && len(hrefValue) > 0 {
+			} else if "base" == token.Data {
 
-					isSecure := mainPageSecure
-					if strings.HasPrefix(hrefValue, "https:") {
-						isSecure = true
+				// Only the first valid <base> is considered
+				if !baseOverride {
+					for _, attr := range token.Attr {
+						if attr.Key == "href" {
+							if documentBaseUrl := getBaseUrl(pageUrl, sanitizeString(attr.Val)); documentBaseUrl != nil {
+								baseUrl = documentBaseUrl
+								baseOverride = true
+							}
+							break
+						}
 					}
+				}
 
-					normalizedHrefValue, fragment := getAbsoluteNormalized(pageUrl, hrefValue)
+			} else if attributes, found := linkAttributes[token.Data]; found {
 
-					if len(normalizedHrefValue) > 0 {
-						link := NewMarker(
-							crawlingTime,
-							invertedPageHost,
-							isSecure,
-							*normalizedPageUrl,
-							normalizedHrefValue,
-							fragment,
-							token.Data,
-							extrasValue,
-							dataOrigin)
+				for _, attribute := range attributes {
+					var hrefValue string
+					var extrasValue string
+					linkAttributeFound := false
+
+					for _, attr := range token.Attr {
+						if attr.Key == attribute.urlKey {
+							hrefValue = strings.TrimSpace(attr.Val)
+							linkAttributeFound = true
+						} else if attr.Key == attribute.extrasKey {
+							extrasValue = attr.Val
+						}
+					}
+					if len(attribute.extrasKey) == 0 {
+						extrasValue = attribute.extras
+					}
 
-						pageLinks.append(&link)
+					if !linkAttributeFound || len(hrefValue) == 0 {
+						continue
 					}
 
+					if attribute.srcset {
+						// One marker for each image candidate, with its descriptor
+						for _, candidate := range parseSrcset(hrefValue) {
+							if link, ok := newLink(token.Data, sanitizeString(candidate.URL), candidate.Descriptor); ok {
+								pageLinks.Append(&link)
+							}
+						}
+					} else if link, ok := newLink(token.Data, sanitizeString(hrefValue), extrasValue); ok {
+						if attribute.hyperlink {
+							setHyperlinkAttributes(&link, token.Attr)
+						}
+						pageLinks.Append(&link)
+					}
 				}
 
 			}
 
 		} else if tokenType == html.ErrorToken {
 			err := tokenizer.Err()
-			if err == io.EOF {
-				//end of the file, break out of the loop
-				break
+			if err != io.EOF {
+				// The body could not be read or decoded till the end
+				logger.Exceptions <- Exception{
+					SourcePage:      *normalizedPageUrl,
+					ErrorType:       "Body reading failed",
+					OriginalMessage: err.Error(),
+				}
 			}
+			//end of the file, break out of the loop
+			break
 		}
 
 	}
 
-	return &pageLinks
+	return pageLinks, canonical
 }
 
 
-func WriteParquet(destination string, writersChannel chan *MarkersList, failed *abool.AtomicBool, done chan bool, logger Logger) {
+// Writes the chunks received from the channel to the sink until it is closed and sends
+// the outcome on done. After a failure the remaining chunks are discarded so that the reader
+// is never blocked, and the output is finalized anyway to keep the rows already written readable.
+func WriteMarkers(sink Sink, writersChannel chan *MarkerBatch, budget *MemoryBudget,
+	failed *abool.AtomicBool, done chan error, logger Logger) {
 
-	//fmt.Println("Write in", destination)
-	fw, err := local.NewLocalFileWriter(destination)
-	if err != nil {
+	var writerErr error
+
+	// Iterate until it is open
+	for linksChunk := range writersChannel {
+		if writerErr != nil {
+			// The writer already failed, just consume the chunk
+			budget.release(linksChunk.size)
+			linksChunk.Release()
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "New write request: %d links, memory budget %d/%d MB, heap %d MB\n",
+			linksChunk.Len(), budget.Used()/MB, budget.Limit()/MB, heapInUse()/MB)
+		if err := sink.WriteBatch(linksChunk); err != nil {
+			failed.Set()
+			logger.Exceptions <- Exception{
+				//Source:          exceptionsSource,
+				ErrorType:       "Write failed",
+				Message:         "Impossible to write the record",
+				OriginalMessage: err.Error(),
+			}
+			writerErr = err
+		}
+		budget.release(linksChunk.size)
+		linksChunk.Release()
+	}
+
+	if err := sink.Close(); err != nil {
 		failed.Set()
 		logger.Exceptions <- Exception{
 			//Source:          exceptionsSource,
 			ErrorType:       "Write failed",
-			Message:         "Impossible to create the file",
+			Message:         "Impossible to finalize the file",
 			OriginalMessage: err.Error(),
 		}
-		panic(err)
-		// LOG IMPOSSIBLE TO CREATE THE FILE
-	} else {
-		//write
-		pw, err := writer.NewParquetWriter(fw, new(Marker), 1)
-		if err != nil {
-			failed.Set()
-			// LOG IMPOSSIBLE TO CREATE THE FILE
-			panic(err)
-		} else {
-
-			pw.RowGroupSize = 8 * 1024 * 1024
-			pw.CompressionType = parquet.CompressionCodec_GZIP
-			pw.PageSize = 2 * 1024 * 1024
-
-			// Iterate until it is open
-			for linksChunk := range writersChannel {
-				fmt.Println("New write request:", linksChunk.length, "links")
-				for node := linksChunk.head; node != nil; node = node.next {
-					if err := pw.Write(node.Marker); err != nil {
-						failed.Set()
-						logger.Exceptions <- Exception{
-							//Source:          exceptionsSource,
-							ErrorType:       "Write failed",
-							Message:         "Impossible to write the record",
-							OriginalMessage: err.Error(),
-						}
-						//LOG ERROR IN WRITING
-						panic(err)
-
-					}
-				}
-			}
-
-			if err := pw.WriteStop(); err != nil {
-				failed.Set()
-				logger.Exceptions <- Exception{
-					//Source:          exceptionsSource,
-					ErrorType:       "Write failed",
-					Message:         "Impossible to finalize the file",
-					OriginalMessage: err.Error(),
-				}
-				// LOG IMPOSSIBLE TO FINALISE THE FILE
-				panic(err)
-			}
-			fw.Close()
+		if writerErr == nil {
+			writerErr = err
 		}
-
 	}
 
-	done <- true
+	done <- writerErr
 }
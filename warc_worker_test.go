@@ -0,0 +1,435 @@
+package main
+
+import (
+	"errors"
+	"github.com/tevino/abool"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Creates a logger writing into a temporary directory
+func newTestLogger(t *testing.T, dir string) Logger {
+	logger, err := NewLogger("test.warc", dir+"/", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go logger.run()
+	return logger
+}
+
+func TestLinkExtractionWorkerErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := newTestLogger(t, dir)
+	defer logger.quit()
+
+	_, err = LinkExtractionWorker(path.Join(dir, "missing.warc"), path.Join(dir, "missing.parquet"), "test", ExtractionConfig{}, logger)
+	var inputErr *InputError
+	if !errors.As(err, &inputErr) {
+		t.Errorf("expected an InputError, got %v", err)
+	}
+
+	page := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<a href=\"/about\">About</a>"
+	warcPath := path.Join(dir, "truncated.warc")
+	content := testWarcResponse("http://example.com/", page) + "NOT A WARC RECORD\r\n\r\n"
+	if err := ioutil.WriteFile(warcPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := path.Join(dir, "truncated.parquet")
+	_, err = LinkExtractionWorker(warcPath, outputPath, "test", ExtractionConfig{}, logger)
+	var recordErr *RecordError
+	if !errors.As(err, &recordErr) {
+		t.Errorf("expected a RecordError, got %v", err)
+	}
+	if info, err := os.Stat(outputPath); err != nil || info.Size() == 0 {
+		t.Errorf("expected the partial output to be finalized")
+	}
+
+	stats, err := LinkExtractionWorker(warcPath, outputPath, "test", ExtractionConfig{MaxRecordErrors: 1}, logger)
+	if err != nil {
+		t.Errorf("expected the malformed record to be skipped, got %v", err)
+	}
+	// The page and its link
+	if stats.Rows != 2 {
+		t.Errorf("expected 2 rows written, got %d", stats.Rows)
+	}
+	if info, err := os.Stat(outputPath); err != nil || info.Size() != stats.Bytes {
+		t.Errorf("expected %d bytes written, got %v %v", stats.Bytes, info, err)
+	}
+
+	_, err = LinkExtractionWorker(warcPath, path.Join(dir, "no", "such", "dir.parquet"), "test", ExtractionConfig{}, logger)
+	var writerErr *WriterError
+	if !errors.As(err, &writerErr) || writerErr.Op != "create" {
+		t.Errorf("expected a WriterError on create, got %v", err)
+	}
+}
+
+func TestLinkExtractionWorkerStdin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sequencer-worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := newTestLogger(t, dir)
+	defer logger.quit()
+
+	page := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<a href=\"/about\">About</a>"
+	records := []string{testWarcResponse("http://example.com/", page), testWarcResponse("http://example.com/other", page)}
+	data, offsets := zstdFrames(t, testZstdDictionary(t, records...), true, records...)
+	stdin, err := os.Create(path.Join(dir, "stdin.warc.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	if _, err := stdin.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdin.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	originalStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = originalStdin }()
+
+	config := ExtractionConfig{Format: FORMAT_TSV}
+	stats, err := LinkExtractionWorker(STDIN_PATH, path.Join(dir, "out.tsv"), "test", config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two pages and their links
+	if stats.Rows != 4 {
+		t.Errorf("expected 4 rows written, got %d", stats.Rows)
+	}
+
+	content, err := ioutil.ReadFile(path.Join(dir, "out.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The markers reference the frames of their records
+	for i, offset := range offsets {
+		reference := "\t" + STDIN_PATH + "\t" + strconv.FormatInt(offset, 10) + "\t"
+		if strings.Count(string(content), reference) != 2 {
+			t.Errorf("expected 2 markers of record %d at %d in %q", i, offset, content)
+		}
+	}
+}
+
+// Runs ReadWarc on the WARC content and returns the extracted markers
+func readTestWarc(t *testing.T, content string) []Marker {
+	return readTestWarcWithConfig(t, content, ExtractionConfig{})
+}
+
+func readTestWarcWithConfig(t *testing.T, content string, config ExtractionConfig) []Marker {
+	dir, err := ioutil.TempDir("", "sequencer-read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := newTestLogger(t, dir)
+	defer logger.quit()
+
+	recordsReader, err := NewWarcReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.MemoryBudget == nil {
+		config.MemoryBudget = NewMemoryBudget(DEFAULT_MEMORY_BUDGET)
+	}
+
+	writerChannel := make(chan *MarkerBatch)
+	collected := make(chan []Marker)
+	go func() {
+		var markers []Marker
+		for chunk := range writerChannel {
+			markers = append(markers, batchMarkers(chunk)...)
+			config.MemoryBudget.release(chunk.size)
+			chunk.Release()
+		}
+		collected <- markers
+	}()
+
+	if err := ReadWarc("test", "test.warc", recordsReader, writerChannel, abool.New(), config, logger); err != nil {
+		t.Fatal(err)
+	}
+	close(writerChannel)
+	return <-collected
+}
+
+// Runs getLinks on the HTML page and returns the extracted markers
+func extractTestLinks(t *testing.T, page string, body string) []Marker {
+	dir, err := ioutil.TempDir("", "sequencer-links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := newTestLogger(t, dir)
+	defer logger.quit()
+
+	pageUrl, err := url.Parse(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, _ := getLinks("test", 0, pageUrl, &page, strings.NewReader(body), logger, "com.example")
+	defer links.Release()
+	return batchMarkers(links)
+}
+
+func TestGetLinksEmbeddedResources(t *testing.T) {
+	body := `<html><body>
+		<img src="/logo.png" alt="Logo" srcset="/logo-2x.png 2x, /logo,wide.png 800w">
+		<picture><source srcset="/photo.webp" type="image/webp"></picture>
+		<iframe src="https://player.example.org/embed/1" title="Player"></iframe>
+		<embed src="/movie.swf" type="application/x-shockwave-flash">
+		<object data="/doc.pdf" type="application/pdf"></object>
+		<video src="/clip.mp4" poster="/clip.jpg"><track src="/clip.vtt" kind="captions"></video>
+		<audio src="/song.mp3"></audio>
+		</body></html>`
+
+	expected := []struct{ tag, link, extras string }{
+		{"img", "http://example.com/logo.png", "Logo"},
+		{"img", "http://example.com/logo-2x.png", "2x"},
+		{"img", "http://example.com/logo,wide.png", "800w"},
+		{"source", "http://example.com/photo.webp", ""},
+		{"iframe", "http://player.example.org/embed/1", "Player"},
+		{"embed", "http://example.com/movie.swf", "application/x-shockwave-flash"},
+		{"object", "http://example.com/doc.pdf", "application/pdf"},
+		{"video", "http://example.com/clip.mp4", "src"},
+		{"video", "http://example.com/clip.jpg", "poster"},
+		{"track", "http://example.com/clip.vtt", "captions"},
+		{"audio", "http://example.com/song.mp3", "src"},
+	}
+
+	markers := extractTestLinks(t, "http://example.com/", body)
+	if len(markers) != len(expected) {
+		t.Fatalf("expected %d markers, got %d: %v", len(expected), len(markers), markers)
+	}
+	for i, marker := range markers {
+		if marker.Tag != expected[i].tag || marker.Link != expected[i].link || marker.Extras != expected[i].extras {
+			t.Errorf("marker %d: got %s %s %q, expected %v", i, marker.Tag, marker.Link, marker.Extras, expected[i])
+		}
+	}
+}
+
+func TestGetLinksBase(t *testing.T) {
+	body := `<html><head>
+		<link rel="stylesheet" href="style.css">
+		<base href="javascript:void(0)">
+		<base href="https://cdn.example.org/assets/">
+		<base href="http://ignored.example.org/">
+		</head><body>
+		<a href="page.html">Page</a>
+		<img src="/logo.png">
+		<a href="http://other.example.net/">Other</a>
+		<a href="https://secure.example.net/">Secure</a>
+		</body></html>`
+
+	expected := []struct {
+		link         string
+		baseOverride bool
+		secure       bool
+	}{
+		{"http://example.com/dir/style.css", false, false},
+		{"http://cdn.example.org/assets/page.html", true, true},
+		{"http://cdn.example.org/logo.png", true, true},
+		{"http://other.example.net", true, false},
+		{"http://secure.example.net", true, true},
+	}
+
+	markers := extractTestLinks(t, "http://example.com/dir/index.html", body)
+	if len(markers) != len(expected) {
+		t.Fatalf("expected %d markers, got %d: %v", len(expected), len(markers), markers)
+	}
+	for i, marker := range markers {
+		if marker.Link != expected[i].link || marker.BaseOverride != expected[i].baseOverride || marker.Secure != expected[i].secure {
+			t.Errorf("marker %d: got %s %v %v, expected %v", i, marker.Link, marker.BaseOverride, marker.Secure, expected[i])
+		}
+	}
+}
+
+func TestRefreshRedirects(t *testing.T) {
+	page := "HTTP/1.1 200 OK\r\n" +
+		"Content-Type: text/html\r\n" +
+		"Refresh: 5; url=/moved\r\n" +
+		"\r\n" +
+		"<html><head><base href=\"http://example.com/base/\">" +
+		"<META HTTP-EQUIV=\"Refresh\" CONTENT=\"0;URL='next.html'\">" +
+		"<meta http-equiv=\"refresh\" content=\"30\">" +
+		"</head></html>"
+
+	markers := readTestWarc(t, testWarcResponse("http://example.com/page", page))
+
+	expected := []struct{ tag, link, extras string }{
+		{REFRESH_HEADER_TAG, "http://example.com/moved", "5"},
+		{META_REFRESH_TAG, "http://example.com/base/next.html", "0"},
+		{"200", "", ""},
+	}
+	if len(markers) != len(expected) {
+		t.Fatalf("expected %d markers, got %d: %v", len(expected), len(markers), markers)
+	}
+	for i, marker := range markers {
+		if marker.Tag != expected[i].tag || marker.Link != expected[i].link || marker.Extras != expected[i].extras {
+			t.Errorf("marker %d: got %s %s %q, expected %v", i, marker.Tag, marker.Link, marker.Extras, expected[i])
+		}
+	}
+}
+
+func TestLinkRelSemantics(t *testing.T) {
+	page := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n" +
+		"<html><head>" +
+		"<link rel=\"stylesheet\" href=\"/style.css\" type=\"text/css\">" +
+		"<link REL=\"Canonical\" href=\"/page?b=2&a=1#top\">" +
+		"<link rel=\"canonical\" href=\"/ignored\">" +
+		"<link rel=\"alternate\" hreflang=\"de-DE\" href=\"/de/page\">" +
+		"<link rel=\"alternate\" media=\"only screen and (max-width: 640px)\" href=\"http://m.example.com/page\">" +
+		"<link rel=\"alternate\" type=\"application/rss+xml\" href=\"/feed\">" +
+		"<link rel=\"next  prev next\" href=\"/page/2\">" +
+		"<link rel=\"amphtml\" href=\"/amp/page\">" +
+		"</head></html>"
+
+	markers := readTestWarc(t, testWarcResponse("http://example.com/page", page))
+
+	expected := []struct{ link, rel, hreflang, extras string }{
+		{"http://example.com/style.css", "stylesheet", "", "text/css"},
+		{"http://example.com/page?a=1&b=2", "canonical", "", ""},
+		{"http://example.com/ignored", "canonical", "", ""},
+		{"http://example.com/de/page", "alternate", "de-de", ""},
+		{"http://m.example.com/page", "alternate", "", "only screen and (max-width: 640px)"},
+		{"http://example.com/feed", "alternate", "", "application/rss+xml"},
+		{"http://example.com/page/2", "next prev", "", ""},
+		{"http://example.com/amp/page", "amphtml", "", ""},
+	}
+	if len(markers) != len(expected)+1 {
+		t.Fatalf("expected %d markers, got %d: %v", len(expected)+1, len(markers), markers)
+	}
+	for i, e := range expected {
+		marker := markers[i]
+		if marker.Tag != "link" || marker.Link != e.link || marker.Rel != e.rel || marker.Hreflang != e.hreflang || marker.Extras != e.extras {
+			t.Errorf("marker %d: got %s %q %q %q, expected %v", i, marker.Link, marker.Rel, marker.Hreflang, marker.Extras, e)
+		}
+	}
+
+	pageMarker := markers[len(expected)]
+	if pageMarker.Tag != "200" || pageMarker.Canonical != "http://example.com/page?a=1&b=2" {
+		t.Errorf("unexpected page marker %+v", pageMarker)
+	}
+}
+
+func TestGetLinksHyperlinkAttributes(t *testing.T) {
+	body := `<html><body>` +
+		`<a href="/ad" REL="Sponsored NOFOLLOW noopener" target="_blank" title=" Our partner " hreflang="EN-us">ad</a>` +
+		`<a href="/comment" rel="ugc nofollow ugc">comment</a>` +
+		`<a href="/plain">plain</a>` +
+		`<map><area href="/zone" alt="zone" rel="nofollow" target="_top" title="Zone"></map>` +
+		`<img src="/image.png" title="not a hyperlink">` +
+		`</body></html>`
+
+	links := extractTestLinks(t, "http://example.com/", body)
+
+	expected := []Marker{
+		{Link: "http://example.com/ad", Tag: "a", Extras: "ad", Rel: "nofollow noopener sponsored", Target: "_blank", Title: "Our partner", Hreflang: "en-us"},
+		{Link: "http://example.com/comment", Tag: "a", Extras: "comment", Rel: "nofollow ugc"},
+		{Link: "http://example.com/plain", Tag: "a", Extras: "plain"},
+		{Link: "http://example.com/zone", Tag: "area", Extras: "zone", Rel: "nofollow", Target: "_top", Title: "Zone"},
+		{Link: "http://example.com/image.png", Tag: "img"},
+	}
+	if len(links) != len(expected) {
+		t.Fatalf("expected %d links, got %d: %v", len(expected), len(links), links)
+	}
+	for i, e := range expected {
+		link := links[i]
+		if link.Link != e.Link || link.Tag != e.Tag || link.Extras != e.Extras || link.Rel != e.Rel ||
+			link.Target != e.Target || link.Title != e.Title || link.Hreflang != e.Hreflang {
+			t.Errorf("link %d: got %+v, expected %+v", i, link, e)
+		}
+	}
+}
+
+func TestWarcProvenance(t *testing.T) {
+	first := strings.Replace(testWarcResponse("http://example.com/",
+		"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<a href=\"/next\">next</a>"),
+		"Content-Type:", "WARC-Payload-Digest: sha1:PAYLOAD\r\nContent-Type:", 1)
+	second := testWarcResponse("http://example.com/missing", "HTTP/1.1 404 Not Found\r\n\r\n")
+
+	markers := readTestWarc(t, first+second)
+	if len(markers) != 3 {
+		t.Fatalf("expected 3 markers, got %d: %v", len(markers), markers)
+	}
+
+	link, firstPage, secondPage := markers[0], markers[1], markers[2]
+	if link.WarcFile != "test.warc" || link.WarcOffset != 0 || link.WarcRecordId != "" {
+		t.Errorf("unexpected reference of the link %+v", link)
+	}
+	if firstPage.WarcFile != "test.warc" || firstPage.WarcOffset != 0 || firstPage.WarcLength != int64(len(first)) ||
+		firstPage.WarcRecordId != "<urn:uuid:00000013-0000-0000-0000-000000000000>" || firstPage.WarcPayloadDigest != "sha1:PAYLOAD" {
+		t.Errorf("unexpected provenance of the first page %+v", firstPage)
+	}
+	if secondPage.WarcOffset != int64(len(first)) || secondPage.WarcLength != int64(len(second)) {
+		t.Errorf("unexpected provenance of the second page %+v", secondPage)
+	}
+}
+
+func TestRevisitRecords(t *testing.T) {
+	digest := "sha1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	original := strings.Replace(testWarcResponse("http://example.com/",
+		"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n"+
+			"<link rel=\"canonical\" href=\"/home\"><a href=\"/next\">next</a>"),
+		"Content-Type:", "WARC-Payload-Digest: "+digest+"\r\nContent-Type:", 1)
+
+	revisitHeader := "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n"
+	revisit := "WARC/1.0\r\n" +
+		"WARC-Type: revisit\r\n" +
+		"WARC-Date: 2019-07-28T10:00:00Z\r\n" +
+		"WARC-Target-URI: http://www.example.com/\r\n" +
+		"WARC-Payload-Digest: " + digest + "\r\n" +
+		"WARC-Profile: http://netpreserve.org/warc/1.0/revisit/identical-payload-digest\r\n" +
+		"WARC-Refers-To-Target-URI: http://example.com/\r\n" +
+		"WARC-Refers-To-Date: 2019-07-27T10:00:00Z\r\n" +
+		"Content-Type: message/http\r\n" +
+		"Content-Length: " + strconv.Itoa(len(revisitHeader)) + "\r\n" +
+		"\r\n" + revisitHeader + "\r\n\r\n"
+	emptyRevisit := strings.Replace(strings.Replace(revisit, revisitHeader, "", 1),
+		"Content-Length: "+strconv.Itoa(len(revisitHeader)), "Content-Length: 0", 1)
+
+	markers := readTestWarc(t, original+revisit+emptyRevisit)
+	if len(markers) != 5 {
+		t.Fatalf("expected 5 markers, got %d: %v", len(markers), markers)
+	}
+	for _, page := range markers[3:] {
+		if !page.Revisit || page.Source != "http://www.example.com" || page.RefersToUri != "http://example.com" ||
+			page.RefersToDate != 1564221600 || page.Date != 1564308000 || page.WarcPayloadDigest != digest {
+			t.Errorf("unexpected revisit marker %+v", page)
+		}
+	}
+	if markers[3].Tag != "200" || markers[4].Tag != "" || markers[2].Revisit {
+		t.Errorf("unexpected page markers %+v", markers[2:])
+	}
+
+	markers = readTestWarcWithConfig(t, original+revisit, ExtractionConfig{CopyRevisitLinks: true})
+	if len(markers) != 6 {
+		t.Fatalf("expected 6 markers, got %d: %v", len(markers), markers)
+	}
+	for i, copied := range markers[3:5] {
+		if copied != (Marker{Date: 1564308000, SourceHost: "com.example.www", Source: "http://www.example.com",
+			Link: markers[i].Link, Tag: markers[i].Tag, Rel: markers[i].Rel, Extras: markers[i].Extras, DataOrigin: "test",
+			WarcFile: "test.warc", WarcOffset: int64(len(original))}) {
+			t.Errorf("unexpected copied link %+v", copied)
+		}
+	}
+	if markers[5].Canonical != "http://example.com/home" {
+		t.Errorf("unexpected revisit marker %+v", markers[5])
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var errReservedZstdBlock = errors.New("reserved zstd block type")
+
+// Checks if the bytes start a skippable frame, whose magic is 0x184D2A50 to 0x184D2A5F
+func isZstdSkippableFrame(magic []byte) bool {
+	return len(magic) >= 4 && magic[0]&0xf0 == 0x50 && magic[1] == 0x2a && magic[2] == 0x4d && magic[3] == 0x18
+}
+
+// Prepares the reading of a zstd WARC frame by frame, each frame holding a record.
+// The .warc.zst format starts with a skippable frame holding the dictionary of the
+// frames, possibly compressed with zstd itself.
+func (r *WarcReader) startZstd() error {
+	options := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	magic, _ := r.input.Peek(4)
+	if isZstdSkippableFrame(magic) && binary.LittleEndian.Uint32(magic) == zstdDictionaryMagic {
+		dictionary, err := r.readZstdDictionary()
+		if err != nil {
+			return err
+		}
+		options = append(options, zstd.WithDecoderDicts(dictionary))
+	}
+
+	decoder, err := zstd.NewReader(nil, options...)
+	if err != nil {
+		return err
+	}
+	r.zstd = decoder
+	r.frame = &zstdFrameReader{}
+	r.members = true
+	r.records = bufio.NewReader(strings.NewReader(""))
+
+	if err := r.skipZstdSkippableFrames(); err != nil {
+		return err
+	}
+	r.memberOffset = r.position()
+	if err := r.resetMember(); err != nil && err != io.EOF {
+		// Without frames the WARC is empty
+		return err
+	}
+	return nil
+}
+
+// Consumes the skippable frame of the dictionary and returns the dictionary
+func (r *WarcReader) readZstdDictionary() ([]byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r.input, header); err != nil {
+		return nil, fmt.Errorf("truncated zstd dictionary frame: %s", err)
+	}
+	size := int64(binary.LittleEndian.Uint32(header[4:]))
+	dictionary, err := ioutil.ReadAll(io.LimitReader(r.input, size))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(dictionary)) < size {
+		return nil, fmt.Errorf("truncated zstd dictionary frame: %s", io.ErrUnexpectedEOF)
+	}
+
+	if bytes.HasPrefix(dictionary, zstdMagic) {
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		if dictionary, err = decoder.DecodeAll(dictionary, nil); err != nil {
+			return nil, fmt.Errorf("invalid zstd dictionary: %s", err)
+		}
+	}
+	return dictionary, nil
+}
+
+// Consumes the skippable frames at the current position, they hold no records
+func (r *WarcReader) skipZstdSkippableFrames() error {
+	for {
+		header, _ := r.input.Peek(8)
+		if len(header) < 8 || !isZstdSkippableFrame(header) {
+			return nil
+		}
+		size := int(binary.LittleEndian.Uint32(header[4:]))
+		r.input.Discard(len(header))
+		if _, err := r.input.Discard(size); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+}
+
+// Reads a single zstd frame of the input and returns io.EOF at its end, so that the
+// decoder stops at the end of the frame like the gzip reader at the end of a member.
+// Only the frame and block headers are parsed to find the end of the frame.
+type zstdFrameReader struct {
+	input *bufio.Reader
+	// Bytes left of the header, block or checksum being read
+	left      int
+	lastBlock bool
+	checksum  bool
+	done      bool
+}
+
+// Starts reading the frame at the current position of the input.
+// It returns io.EOF at the end of the input.
+func (f *zstdFrameReader) reset(input *bufio.Reader) error {
+	header, err := input.Peek(6)
+	if len(header) == 0 && err == io.EOF {
+		return io.EOF
+	}
+	if len(header) < 5 {
+		return io.ErrUnexpectedEOF
+	}
+	if !bytes.Equal(header[:4], zstdMagic) {
+		return errors.New("invalid zstd frame magic")
+	}
+
+	descriptor := header[4]
+	singleSegment := descriptor&0x20 != 0
+	size := len(zstdMagic) + 1
+	if !singleSegment {
+		// Window descriptor
+		size++
+	}
+	size += [4]int{0, 1, 2, 4}[descriptor&0x3]
+	switch descriptor >> 6 {
+	case 0:
+		if singleSegment {
+			size++
+		}
+	case 1:
+		size += 2
+	case 2:
+		size += 4
+	case 3:
+		size += 8
+	}
+
+	*f = zstdFrameReader{input: input, left: size, checksum: descriptor&0x4 != 0}
+	return nil
+}
+
+func (f *zstdFrameReader) Read(p []byte) (int, error) {
+	for f.left == 0 {
+		if f.done {
+			return 0, io.EOF
+		}
+		if f.lastBlock {
+			f.done = true
+			if f.checksum {
+				f.left = 4
+			}
+			continue
+		}
+
+		header, err := f.input.Peek(3)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		blockHeader := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+		f.lastBlock = blockHeader&0x1 != 0
+		blockSize := blockHeader >> 3
+		switch (blockHeader >> 1) & 0x3 {
+		case 1:
+			// RLE block, a single byte repeated blockSize times
+			blockSize = 1
+		case 3:
+			return 0, errReservedZstdBlock
+		}
+		f.left = len(header) + blockSize
+	}
+
+	if len(p) > f.left {
+		p = p[:f.left]
+	}
+	n, err := f.input.Read(p)
+	f.left -= n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
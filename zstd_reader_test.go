@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// Compresses each record in its own zstd frame as in the .warc.zst format, after the skippable
+// frame of the dictionary if any. It returns the data and the offset of each frame.
+func zstdFrames(t *testing.T, dictionary []byte, compressDictionary bool, records ...string) ([]byte, []int64) {
+	var buffer bytes.Buffer
+	options := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if dictionary != nil {
+		payload := dictionary
+		if compressDictionary {
+			encoder, err := zstd.NewWriter(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload = encoder.EncodeAll(dictionary, nil)
+		}
+		header := make([]byte, 8)
+		binary.LittleEndian.PutUint32(header, zstdDictionaryMagic)
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(payload)))
+		buffer.Write(header)
+		buffer.Write(payload)
+		options = append(options, zstd.WithEncoderDict(dictionary))
+	}
+	encoder, err := zstd.NewWriter(nil, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for _, record := range records {
+		offsets = append(offsets, int64(buffer.Len()))
+		buffer.Write(encoder.EncodeAll([]byte(record), nil))
+	}
+	return buffer.Bytes(), offsets
+}
+
+// Dictionary of the records, trained on variations of them
+func testZstdDictionary(t *testing.T, records ...string) []byte {
+	random := rand.New(rand.NewSource(1))
+	var contents [][]byte
+	var history []byte
+	for i := 0; i < 64; i++ {
+		for _, record := range records {
+			sample := []byte(record)
+			for j := 0; j < 16; j++ {
+				sample = append(sample, byte('a'+random.Intn(26)))
+			}
+			contents = append(contents, sample)
+		}
+	}
+	for _, record := range records {
+		history = append(history, record...)
+	}
+	dictionary, err := zstd.BuildDict(zstd.BuildDictOptions{ID: 42, Contents: contents, History: history, Offsets: [3]int{1, 4, 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dictionary
+}
+
+func TestWarcReaderZstd(t *testing.T) {
+	records := []string{
+		testWarcResponse("http://example.com/first", "HTTP/1.1 200 OK\r\n\r\nfirst"),
+		testWarcResponse("http://example.com/second", "HTTP/1.1 200 OK\r\n\r\nsecond"),
+	}
+	dictionary := testZstdDictionary(t, records...)
+
+	for _, test := range []struct {
+		name               string
+		dictionary         []byte
+		compressDictionary bool
+	}{
+		{"no dictionary", nil, false},
+		{"dictionary", dictionary, false},
+		{"compressed dictionary", dictionary, true},
+	} {
+		data, offsets := zstdFrames(t, test.dictionary, test.compressDictionary, records...)
+		reader, err := NewWarcReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		for i, offset := range offsets {
+			record, targetUri := readTargetUri(t, reader)
+			length := reader.RecordLength()
+			if record.Offset != offset || length <= 0 {
+				t.Fatalf("%s: record %d at %d with length %d, expected offset %d", test.name, i, record.Offset, length, offset)
+			}
+
+			// The frame can be read again from its offset and length, after the dictionary
+			replayData := append(append([]byte(nil), data[:offsets[0]]...), data[offset:offset+length]...)
+			replay, err := NewWarcReader(bytes.NewReader(replayData))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, replayedUri := readTargetUri(t, replay); replayedUri != targetUri {
+				t.Errorf("%s: replayed %s, expected %s", test.name, replayedUri, targetUri)
+			}
+			if _, err := replay.ReadRecord(); err != io.EOF {
+				t.Errorf("%s: expected a single record, got %v", test.name, err)
+			}
+			replay.Close()
+		}
+		if _, err := reader.ReadRecord(); err != io.EOF {
+			t.Errorf("%s: expected EOF, got %v", test.name, err)
+		}
+		reader.Close()
+	}
+
+	// Only the dictionary
+	data, _ := zstdFrames(t, dictionary, false)
+	reader, err := NewWarcReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.ReadRecord(); err != io.EOF {
+		t.Errorf("expected EOF for a WARC without records, got %v", err)
+	}
+}
+
+func TestWarcReaderZstdResync(t *testing.T) {
+	page := "HTTP/1.1 200 OK\r\n\r\nhello"
+	data, offsets := zstdFrames(t, nil, false,
+		testWarcResponse("http://example.com/1", page),
+		testWarcResponse("http://example.com/2", page),
+		testWarcResponse("http://example.com/3", page))
+
+	// Corrupt the blocks of the second frame, keeping its headers
+	corrupted := append([]byte(nil), data...)
+	for i := offsets[1] + 12; i < offsets[2]-8; i++ {
+		corrupted[i] ^= 0xff
+	}
+
+	reader, err := NewWarcReader(bytes.NewReader(corrupted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if _, uri := readTargetUri(t, reader); uri != "http://example.com/1" {
+		t.Errorf("unexpected record %s", uri)
+	}
+
+	_, err = reader.ReadRecord()
+	var recordErr *RecordError
+	if !errors.As(err, &recordErr) || recordErr.Offset != offsets[1] {
+		t.Fatalf("expected a RecordError at %d, got %v", offsets[1], err)
+	}
+
+	if err := reader.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	record, uri := readTargetUri(t, reader)
+	if uri != "http://example.com/3" || record.Offset != offsets[2] {
+		t.Errorf("unexpected record %s at %d", uri, record.Offset)
+	}
+	if _, err := reader.ReadRecord(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}